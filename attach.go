@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"gopf/config"
+	"gopf/control"
+	"gopf/ui"
+	"os"
+)
+
+// runUI 运行界面。加 -attach 时连接到运行中的 gopf（例如 gopf serve），
+// 界面只用来查看和操作规则，退出界面不会停止任何规则。
+// 配置中有 hosts 时同时连接其中的所有实例。
+func runUI(args []string) error {
+	fs := flag.NewFlagSet("ui", flag.ExitOnError)
+	configFile := fs.String("config", defaultConfigFile, "配置文件路径")
+	attach := fs.Bool("attach", false, "连接到运行中的 gopf，而不是在界面中启动规则")
+	socket := fs.String("socket", "", "控制套接字路径，默认使用配置中的 control.socket")
+	server := fs.String("server", "", "通过 TCP 连接运行中的 gopf，例如 10.0.0.2:9470")
+	token := fs.String("token", "", "TCP 连接使用的令牌")
+	fs.Parse(args)
+
+	if !*attach {
+		return runTUI(*configFile)
+	}
+
+	var hosts []ui.Host
+	if *server != "" {
+		hosts = []ui.Host{{Name: *server, Addr: *server, Client: control.NewTCPClient(*server, *token)}}
+	} else {
+		// 配置文件只用来查找要连接的实例，不存在时连接默认套接字
+		cfg, err := config.ReadConfig(*configFile)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("加载配置文件失败: %v", err)
+		}
+		if *socket == "" && cfg != nil && len(cfg.Hosts) > 0 {
+			if hosts, err = configHosts(cfg.Hosts); err != nil {
+				return err
+			}
+		} else {
+			path := controlSocket(cfg, *socket)
+			hosts = []ui.Host{{Name: path, Addr: path, Client: control.NewClient(path)}}
+		}
+	}
+
+	// 只连接一个实例时要求它已在运行；多个实例时连不上的会在界面中自动重连
+	if len(hosts) == 1 {
+		if _, err := hosts[0].Client.Info(); err != nil {
+			if control.Unreachable(err) {
+				return fmt.Errorf("无法连接 %s，请确认 gopf 正在运行并配置了 control", hosts[0].Addr)
+			}
+			return err
+		}
+	}
+	return ui.AttachUI(hosts, version)
+}
+
+// configHosts 根据配置中的 hosts 创建各实例的客户端
+func configHosts(list []config.HostConfig) ([]ui.Host, error) {
+	hosts := make([]ui.Host, 0, len(list))
+	for i, h := range list {
+		host := ui.Host{Name: h.Name}
+		switch {
+		case h.Socket != "" && h.Server != "":
+			return nil, fmt.Errorf("hosts[%d]: socket 和 server 只能设置一个", i)
+		case h.Server != "":
+			host.Addr, host.Client = h.Server, control.NewTCPClient(h.Server, h.Token)
+		case h.Socket != "":
+			host.Addr, host.Client = h.Socket, control.NewClient(h.Socket)
+		default:
+			return nil, fmt.Errorf("hosts[%d]: 需要设置 socket 或 server", i)
+		}
+		if host.Name == "" {
+			host.Name = host.Addr
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts, nil
+}
@@ -0,0 +1,202 @@
+// Package audit 记录每个被转发会话的审计日志。
+//
+// 审计文件只追加写入，每个会话在关闭时写入一条记录，格式为 JSON Lines 或 CSV。
+// CSV 文件在创建时写入表头，列顺序与 csvHeader 一致。
+package audit
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"gopf/config"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	FormatJSONL = "jsonl"
+	FormatCSV   = "csv"
+
+	DefaultFile = "gopf-audit.jsonl"
+)
+
+// 会话关闭原因
+const (
+	ReasonClientClosed   = "client_closed"
+	ReasonUpstreamClosed = "upstream_closed"
+	ReasonClientError    = "client_error"
+	ReasonUpstreamError  = "upstream_error"
+	ReasonQuota          = "quota_exceeded"
+	ReasonFault          = "fault_injected"
+	ReasonStopped        = "rule_stopped"
+)
+
+// Record 一个会话的审计记录
+type Record struct {
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Rule      string    `json:"rule"`
+	Client    string    `json:"client"`
+	Upstream  string    `json:"upstream"`
+	Duration  int64     `json:"duration_ms"`
+	BytesSent uint64    `json:"bytes_sent"`
+	BytesRecv uint64    `json:"bytes_recv"`
+	Reason    string    `json:"reason"`
+}
+
+var csvHeader = []string{"start", "end", "rule", "client", "upstream", "duration_ms", "bytes_sent", "bytes_recv", "reason"}
+
+// Writer 追加写入审计记录，可并发使用
+type Writer struct {
+	mu     sync.Mutex
+	f      *os.File
+	format string
+	csv    *csv.Writer
+}
+
+// Open 按配置打开审计文件，文件不存在时创建
+func Open(cfg *config.AuditConfig) (*Writer, error) {
+	path := cfg.File
+	if path == "" {
+		path = DefaultFile
+	}
+	format := cfg.Format
+	if format == "" {
+		format = FormatOf(path)
+	}
+	if format != FormatJSONL && format != FormatCSV {
+		return nil, fmt.Errorf("未知的审计日志格式: %s", format)
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("打开审计文件失败: %v", err)
+	}
+
+	w := &Writer{f: f, format: format}
+	if format == FormatCSV {
+		w.csv = csv.NewWriter(f)
+		if info, err := f.Stat(); err == nil && info.Size() == 0 {
+			w.csv.Write(csvHeader)
+			w.csv.Flush()
+		}
+	}
+	return w, nil
+}
+
+// FormatOf 根据扩展名推断格式，.csv 为 CSV，其余为 JSON Lines
+func FormatOf(path string) string {
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return FormatCSV
+	}
+	return FormatJSONL
+}
+
+// Write 写入一条记录，每条记录立即落盘
+func (w *Writer) Write(r Record) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.format == FormatCSV {
+		w.csv.Write([]string{
+			r.Start.Format(time.RFC3339Nano),
+			r.End.Format(time.RFC3339Nano),
+			r.Rule,
+			r.Client,
+			r.Upstream,
+			strconv.FormatInt(r.Duration, 10),
+			strconv.FormatUint(r.BytesSent, 10),
+			strconv.FormatUint(r.BytesRecv, 10),
+			r.Reason,
+		})
+		w.csv.Flush()
+		return w.csv.Error()
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = w.f.Write(append(data, '\n'))
+	return err
+}
+
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.f.Close()
+}
+
+// Read 逐条读取审计文件，fn 返回 false 时停止
+func Read(path, format string, fn func(Record) bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if format == "" {
+		format = FormatOf(path)
+	}
+	if format == FormatCSV {
+		return readCSV(f, fn)
+	}
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		if len(strings.TrimSpace(sc.Text())) == 0 {
+			continue
+		}
+		var r Record
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			return fmt.Errorf("第 %d 行: %v", line, err)
+		}
+		if !fn(r) {
+			return nil
+		}
+	}
+	return sc.Err()
+}
+
+func readCSV(f io.Reader, fn func(Record) bool) error {
+	cr := csv.NewReader(f)
+	cr.FieldsPerRecord = len(csvHeader)
+	for line := 1; ; line++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if line == 1 && row[0] == csvHeader[0] {
+			continue
+		}
+
+		var r Record
+		var errs [5]error
+		r.Start, errs[0] = time.Parse(time.RFC3339Nano, row[0])
+		r.End, errs[1] = time.Parse(time.RFC3339Nano, row[1])
+		r.Rule, r.Client, r.Upstream = row[2], row[3], row[4]
+		r.Duration, errs[2] = strconv.ParseInt(row[5], 10, 64)
+		r.BytesSent, errs[3] = strconv.ParseUint(row[6], 10, 64)
+		r.BytesRecv, errs[4] = strconv.ParseUint(row[7], 10, 64)
+		r.Reason = row[8]
+		for _, err := range errs {
+			if err != nil {
+				return fmt.Errorf("第 %d 行: %v", line, err)
+			}
+		}
+		if !fn(r) {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package audit
+
+import (
+	"net"
+	"sort"
+	"time"
+)
+
+// Filter 审计记录的筛选条件，零值字段表示不限制
+type Filter struct {
+	Rule   string
+	Client string // 客户端 IP 或 IP:端口
+	Since  time.Time
+	Until  time.Time
+}
+
+// Match 判断记录是否满足条件，时间范围按会话开始时间计算
+func (f Filter) Match(r Record) bool {
+	if f.Rule != "" && r.Rule != f.Rule {
+		return false
+	}
+	if f.Client != "" && r.Client != f.Client && clientIP(r.Client) != f.Client {
+		return false
+	}
+	if !f.Since.IsZero() && r.Start.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !r.Start.Before(f.Until) {
+		return false
+	}
+	return true
+}
+
+func clientIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// 汇总的分组方式
+const (
+	ByRule   = "rule"
+	ByClient = "client"
+)
+
+// Summary 一组会话的汇总
+type Summary struct {
+	Key       string
+	Sessions  int
+	BytesSent uint64
+	BytesRecv uint64
+	Duration  time.Duration
+	First     time.Time
+	Last      time.Time
+	Reasons   map[string]int
+}
+
+// Summarize 按规则或客户端 IP 汇总，结果按总流量从高到低排列
+func Summarize(records []Record, by string) []Summary {
+	groups := make(map[string]*Summary)
+	for _, r := range records {
+		key := r.Rule
+		if by == ByClient {
+			key = clientIP(r.Client)
+		}
+
+		s, ok := groups[key]
+		if !ok {
+			s = &Summary{Key: key, First: r.Start, Last: r.Start, Reasons: make(map[string]int)}
+			groups[key] = s
+		}
+		s.Sessions++
+		s.BytesSent += r.BytesSent
+		s.BytesRecv += r.BytesRecv
+		s.Duration += time.Duration(r.Duration) * time.Millisecond
+		s.Reasons[r.Reason]++
+		if r.Start.Before(s.First) {
+			s.First = r.Start
+		}
+		if r.Start.After(s.Last) {
+			s.Last = r.Start
+		}
+	}
+
+	out := make([]Summary, 0, len(groups))
+	for _, s := range groups {
+		out = append(out, *s)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		ti, tj := out[i].BytesSent+out[i].BytesRecv, out[j].BytesSent+out[j].BytesRecv
+		if ti != tj {
+			return ti > tj
+		}
+		return out[i].Key < out[j].Key
+	})
+	return out
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"gopf/audit"
+	"gopf/config"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// runAudit 处理 audit 子命令：按规则、客户端或时间范围筛选并汇总审计日志
+func runAudit(args []string) error {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	configFile := fs.String("config", defaultConfigFile, "配置文件路径，用于确定审计文件位置")
+	file := fs.String("file", "", "审计文件路径，默认使用配置中的 audit.file")
+	format := fs.String("format", "", "审计文件格式 jsonl 或 csv，默认按扩展名判断")
+	rule := fs.String("rule", "", "只看指定规则")
+	client := fs.String("client", "", "只看指定客户端 IP 或 IP:端口")
+	since := fs.String("since", "", "开始时间，RFC3339、日期 (2006-01-02) 或距现在的时长 (如 24h)")
+	until := fs.String("until", "", "结束时间，格式同 -since")
+	summary := fs.String("summary", "", "按 rule 或 client 汇总，不指定时逐条列出")
+	fs.Parse(args)
+
+	path, fmtName := *file, *format
+	if path == "" {
+		path = audit.DefaultFile
+		if cfg, err := config.ReadConfig(*configFile); err == nil && cfg.Audit != nil {
+			if cfg.Audit.File != "" {
+				path = cfg.Audit.File
+			}
+			if fmtName == "" {
+				fmtName = cfg.Audit.Format
+			}
+		}
+	}
+	if *summary != "" && *summary != audit.ByRule && *summary != audit.ByClient {
+		return fmt.Errorf("-summary 只能是 %s 或 %s", audit.ByRule, audit.ByClient)
+	}
+
+	filter := audit.Filter{Rule: *rule, Client: *client}
+	var err error
+	if filter.Since, err = parseAuditTime(*since); err != nil {
+		return err
+	}
+	if filter.Until, err = parseAuditTime(*until); err != nil {
+		return err
+	}
+
+	var records []audit.Record
+	err = audit.Read(path, fmtName, func(r audit.Record) bool {
+		if filter.Match(r) {
+			records = append(records, r)
+		}
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("读取审计文件失败: %v", err)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if *summary != "" {
+		fmt.Fprintln(tw, "KEY\tSESSIONS\tSENT\tRECV\tDURATION\tFIRST\tLAST\tREASONS")
+		for _, s := range audit.Summarize(records, *summary) {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\t%s\t%s\t%s\n",
+				s.Key, s.Sessions, s.BytesSent, s.BytesRecv,
+				s.Duration.Round(time.Second),
+				s.First.Local().Format(time.DateTime),
+				s.Last.Local().Format(time.DateTime),
+				formatReasons(s.Reasons))
+		}
+		return tw.Flush()
+	}
+
+	fmt.Fprintln(tw, "START\tRULE\tCLIENT\tUPSTREAM\tDURATION\tSENT\tRECV\tREASON")
+	for _, r := range records {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d\t%d\t%s\n",
+			r.Start.Local().Format(time.DateTime), r.Rule, r.Client, r.Upstream,
+			time.Duration(r.Duration)*time.Millisecond, r.BytesSent, r.BytesRecv, r.Reason)
+	}
+	return tw.Flush()
+}
+
+// parseAuditTime 解析 RFC3339 时间、本地日期或距现在的时长，空字符串表示不限制
+func parseAuditTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("无法解析时间: %s", s)
+}
+
+// formatReasons 将关闭原因计数格式化为 reason=n，按次数从多到少排列
+func formatReasons(reasons map[string]int) string {
+	keys := make([]string, 0, len(reasons))
+	for k := range reasons {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if reasons[keys[i]] != reasons[keys[j]] {
+			return reasons[keys[i]] > reasons[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s=%d", k, reasons[k])
+	}
+	return strings.Join(parts, " ")
+}
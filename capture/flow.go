@@ -0,0 +1,216 @@
+package capture
+
+import (
+	"encoding/binary"
+	"math/rand/v2"
+	"net"
+	"sync"
+)
+
+// TCP 标志位
+const (
+	flagFIN = 0x01
+	flagSYN = 0x02
+	flagPSH = 0x08
+	flagACK = 0x10
+)
+
+// maxSegment 单个合成报文携带的最大负载，保证 IPv4/IPv6 长度字段不溢出
+const maxSegment = 32 * 1024
+
+type endpoint struct {
+	ip   net.IP
+	port uint16
+}
+
+func newEndpoint(addr net.Addr) endpoint {
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		return endpoint{ip: tcp.IP, port: uint16(tcp.Port)}
+	}
+	return endpoint{ip: net.IPv4zero, port: 0}
+}
+
+// Flow 一个转发连接对应的合成 TCP 流，维护双向的序列号。
+// 未在抓包时也需要调用 Data 推进序列号，以便中途开始抓包时序列号仍然连续。
+type Flow struct {
+	mu       sync.Mutex
+	client   endpoint
+	upstream endpoint
+	v6       bool
+	seq      [2]uint32 // 下一个序列号：0 为客户端，1 为上游
+	writer   *Writer   // 已写入握手报文的抓包文件
+}
+
+// NewFlow 根据客户端和上游的真实地址创建合成流
+func NewFlow(client, upstream net.Addr) *Flow {
+	fl := &Flow{
+		client:   newEndpoint(client),
+		upstream: newEndpoint(upstream),
+		seq:      [2]uint32{rand.Uint32(), rand.Uint32()},
+	}
+	fl.v6 = fl.client.ip.To4() == nil || fl.upstream.ip.To4() == nil
+	return fl
+}
+
+// Data 记录一段负载，w 为 nil 时仅推进序列号
+func (fl *Flow) Data(w *Writer, fromClient bool, p []byte) error {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+
+	side := 1
+	if fromClient {
+		side = 0
+	}
+	if w == nil {
+		fl.seq[side] += uint32(len(p))
+		return nil
+	}
+
+	if err := fl.handshake(w); err != nil {
+		return err
+	}
+	for len(p) > 0 {
+		n := min(len(p), maxSegment)
+		if err := fl.emit(w, side, flagPSH|flagACK, p[:n]); err != nil {
+			return err
+		}
+		fl.seq[side] += uint32(n)
+		p = p[n:]
+	}
+	return nil
+}
+
+// Close 写入双方的挥手报文
+func (fl *Flow) Close(w *Writer) error {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+
+	if w == nil || fl.writer != w {
+		return nil
+	}
+
+	if err := fl.emit(w, 0, flagFIN|flagACK, nil); err != nil {
+		return err
+	}
+	fl.seq[0]++
+	if err := fl.emit(w, 1, flagFIN|flagACK, nil); err != nil {
+		return err
+	}
+	fl.seq[1]++
+	return fl.emit(w, 0, flagACK, nil)
+}
+
+// handshake 在流第一次写入该抓包文件时补上三次握手
+func (fl *Flow) handshake(w *Writer) error {
+	if fl.writer == w {
+		return nil
+	}
+
+	fl.seq[0]--
+	fl.seq[1]--
+	if err := fl.emit(w, 0, flagSYN, nil); err != nil {
+		return err
+	}
+	fl.seq[0]++
+	if err := fl.emit(w, 1, flagSYN|flagACK, nil); err != nil {
+		return err
+	}
+	fl.seq[1]++
+	if err := fl.emit(w, 0, flagACK, nil); err != nil {
+		return err
+	}
+
+	fl.writer = w
+	return nil
+}
+
+func (fl *Flow) emit(w *Writer, side int, flags byte, payload []byte) error {
+	src, dst := fl.client, fl.upstream
+	if side == 1 {
+		src, dst = dst, src
+	}
+
+	ack := uint32(0)
+	if flags&flagACK != 0 {
+		ack = fl.seq[1-side]
+	}
+
+	tcp := make([]byte, 20+len(payload))
+	binary.BigEndian.PutUint16(tcp[0:], src.port)
+	binary.BigEndian.PutUint16(tcp[2:], dst.port)
+	binary.BigEndian.PutUint32(tcp[4:], fl.seq[side])
+	binary.BigEndian.PutUint32(tcp[8:], ack)
+	tcp[12] = 5 << 4
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:], 65535)
+	copy(tcp[20:], payload)
+
+	var pkt []byte
+	if fl.v6 {
+		pkt = ipv6Packet(src.ip.To16(), dst.ip.To16(), tcp)
+	} else {
+		pkt = ipv4Packet(src.ip.To4(), dst.ip.To4(), tcp)
+	}
+	return w.writePacket(pkt)
+}
+
+func ipv4Packet(src, dst net.IP, tcp []byte) []byte {
+	pkt := make([]byte, 20+len(tcp))
+	pkt[0] = 0x45
+	binary.BigEndian.PutUint16(pkt[2:], uint16(len(pkt)))
+	binary.BigEndian.PutUint16(pkt[6:], 0x4000) // DF
+	pkt[8] = 64
+	pkt[9] = 6
+	copy(pkt[12:16], src)
+	copy(pkt[16:20], dst)
+	binary.BigEndian.PutUint16(pkt[10:], checksum(pkt[:20], 0))
+
+	pseudo := make([]byte, 12)
+	copy(pseudo[0:4], src)
+	copy(pseudo[4:8], dst)
+	pseudo[9] = 6
+	binary.BigEndian.PutUint16(pseudo[10:], uint16(len(tcp)))
+	binary.BigEndian.PutUint16(tcp[16:], checksum(tcp, sum(pseudo)))
+
+	copy(pkt[20:], tcp)
+	return pkt
+}
+
+func ipv6Packet(src, dst net.IP, tcp []byte) []byte {
+	pkt := make([]byte, 40+len(tcp))
+	pkt[0] = 0x60
+	binary.BigEndian.PutUint16(pkt[4:], uint16(len(tcp)))
+	pkt[6] = 6
+	pkt[7] = 64
+	copy(pkt[8:24], src)
+	copy(pkt[24:40], dst)
+
+	pseudo := make([]byte, 40)
+	copy(pseudo[0:16], src)
+	copy(pseudo[16:32], dst)
+	binary.BigEndian.PutUint32(pseudo[32:], uint32(len(tcp)))
+	pseudo[39] = 6
+	binary.BigEndian.PutUint16(tcp[16:], checksum(tcp, sum(pseudo)))
+
+	copy(pkt[40:], tcp)
+	return pkt
+}
+
+func sum(b []byte) uint32 {
+	var s uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		s += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		s += uint32(b[len(b)-1]) << 8
+	}
+	return s
+}
+
+func checksum(b []byte, initial uint32) uint16 {
+	s := initial + sum(b)
+	for s>>16 != 0 {
+		s = s&0xffff + s>>16
+	}
+	return ^uint16(s)
+}
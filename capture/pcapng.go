@@ -0,0 +1,164 @@
+// Package capture 将转发的负载写入 pcapng 文件。
+//
+// gopf 工作在 TCP 之上，拿不到真实的报文，因此这里根据客户端和上游的真实地址
+// 合成 IP/TCP 头部（包括三次握手、序列号和挥手），使 Wireshark 可以按普通
+// TCP 流进行重组和协议解析。链路类型为 LINKTYPE_RAW，时间戳精度为微秒。
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"gopf/internal/filename"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	blockSHB = 0x0A0D0D0A
+	blockIDB = 0x00000001
+	blockEPB = 0x00000006
+
+	byteOrderMagic = 0x1A2B3C4D
+	linkTypeRaw    = 101
+
+	Extension = ".pcapng"
+)
+
+// ErrLimit 抓包文件达到大小或时长上限
+var ErrLimit = errors.New("抓包已达到上限")
+
+// Writer 写入单个 pcapng 文件，可被多个连接并发调用
+type Writer struct {
+	mu       sync.Mutex
+	path     string
+	file     *os.File
+	buf      *bufio.Writer
+	size     int64
+	maxSize  int64
+	deadline time.Time
+	closed   bool
+}
+
+// Create 在 dir 下为规则创建新的抓包文件，maxSize 和 maxDuration 为 0 表示不限制
+func Create(dir, rule string, maxSize int64, maxDuration time.Duration) (*Writer, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(dir, filename.New(rule, time.Now(), Extension))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Writer{
+		path:    path,
+		file:    file,
+		buf:     bufio.NewWriter(file),
+		maxSize: maxSize,
+	}
+	if maxDuration > 0 {
+		w.deadline = time.Now().Add(maxDuration)
+	}
+
+	w.writeHeader()
+	return w, nil
+}
+
+// Path 返回抓包文件路径
+func (w *Writer) Path() string {
+	return w.path
+}
+
+// Size 返回已写入的字节数
+func (w *Writer) Size() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.size
+}
+
+func (w *Writer) writeHeader() {
+	// Section Header Block
+	shb := make([]byte, 28)
+	binary.LittleEndian.PutUint32(shb[0:], blockSHB)
+	binary.LittleEndian.PutUint32(shb[4:], 28)
+	binary.LittleEndian.PutUint32(shb[8:], byteOrderMagic)
+	binary.LittleEndian.PutUint16(shb[12:], 1)
+	binary.LittleEndian.PutUint16(shb[14:], 0)
+	binary.LittleEndian.PutUint64(shb[16:], ^uint64(0))
+	binary.LittleEndian.PutUint32(shb[24:], 28)
+
+	// Interface Description Block
+	idb := make([]byte, 20)
+	binary.LittleEndian.PutUint32(idb[0:], blockIDB)
+	binary.LittleEndian.PutUint32(idb[4:], 20)
+	binary.LittleEndian.PutUint16(idb[8:], linkTypeRaw)
+	binary.LittleEndian.PutUint32(idb[12:], 0)
+	binary.LittleEndian.PutUint32(idb[16:], 20)
+
+	w.buf.Write(shb)
+	w.buf.Write(idb)
+	w.size = int64(len(shb) + len(idb))
+}
+
+// writePacket 写入一个 Enhanced Packet Block，超过上限时返回 ErrLimit
+func (w *Writer) writePacket(pkt []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return os.ErrClosed
+	}
+
+	padded := (len(pkt) + 3) &^ 3
+	total := 32 + padded
+	if w.maxSize > 0 && w.size+int64(total) > w.maxSize {
+		return ErrLimit
+	}
+	now := time.Now()
+	if !w.deadline.IsZero() && now.After(w.deadline) {
+		return ErrLimit
+	}
+
+	ts := uint64(now.UnixMicro())
+	hdr := make([]byte, 28)
+	binary.LittleEndian.PutUint32(hdr[0:], blockEPB)
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(total))
+	binary.LittleEndian.PutUint32(hdr[8:], 0)
+	binary.LittleEndian.PutUint32(hdr[12:], uint32(ts>>32))
+	binary.LittleEndian.PutUint32(hdr[16:], uint32(ts))
+	binary.LittleEndian.PutUint32(hdr[20:], uint32(len(pkt)))
+	binary.LittleEndian.PutUint32(hdr[24:], uint32(len(pkt)))
+
+	var trailer [4]byte
+	binary.LittleEndian.PutUint32(trailer[:], uint32(total))
+
+	w.buf.Write(hdr)
+	w.buf.Write(pkt)
+	w.buf.Write(make([]byte, padded-len(pkt)))
+	if _, err := w.buf.Write(trailer[:]); err != nil {
+		return err
+	}
+	w.size += int64(total)
+	return nil
+}
+
+// Close 刷新缓冲并关闭文件，可重复调用
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	if err := w.buf.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
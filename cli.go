@@ -0,0 +1,460 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"gopf/config"
+	"gopf/control"
+	"gopf/forwarder"
+	"gopf/stats"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 输出格式
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+// cliFlags 规则管理子命令共用的参数
+type cliFlags struct {
+	config string
+	socket string
+	server string
+	token  string
+	output string
+	json   bool
+}
+
+func (c *cliFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.config, "config", defaultConfigFile, "配置文件路径")
+	fs.StringVar(&c.socket, "socket", "", "控制套接字路径，默认使用配置中的 control.socket")
+	fs.StringVar(&c.server, "server", "", "通过 TCP 连接运行中的 gopf，例如 10.0.0.2:9470")
+	fs.StringVar(&c.token, "token", "", "TCP 连接使用的令牌")
+	fs.StringVar(&c.output, "o", outputTable, "输出格式: table、json 或 yaml")
+	fs.BoolVar(&c.json, "json", false, "以 JSON 输出，等同于 -o json")
+}
+
+func (c *cliFlags) format() (string, error) {
+	if c.json {
+		return outputJSON, nil
+	}
+	switch c.output {
+	case outputTable, outputJSON, outputYAML:
+		return c.output, nil
+	}
+	return "", fmt.Errorf("未知的输出格式: %s", c.output)
+}
+
+// target 子命令操作的对象：运行中的 gopf，或没有运行时直接修改的配置文件
+type target struct {
+	cfg    *config.Config
+	client *control.Client // 为 nil 表示没有运行中的 gopf
+	socket string          // 尝试连接的控制套接字
+}
+
+// connect 优先连接运行中的 gopf，连接不上时退回到配置文件。
+// 配置文件可能属于运行中的 gopf，这里只读取，不写回生成的 ID。
+// create 为 true 时配置文件不存在则新建。
+func (c *cliFlags) connect(create bool) (*target, error) {
+	t := &target{}
+	var err error
+	if t.cfg, err = config.ReadConfig(c.config); os.IsNotExist(err) && create {
+		t.cfg, err = loadConfig(c.config)
+	} else if err != nil && !os.IsNotExist(err) {
+		err = fmt.Errorf("加载配置文件失败: %v", err)
+	}
+	// 指定了远程实例时不需要本地配置
+	if err != nil && c.server == "" {
+		return nil, err
+	}
+
+	if c.server != "" {
+		t.client = control.NewTCPClient(c.server, c.token)
+		if _, err := t.client.Info(); err != nil {
+			return nil, fmt.Errorf("连接 %s 失败: %v", c.server, err)
+		}
+		return t, nil
+	}
+
+	t.socket = controlSocket(t.cfg, c.socket)
+	client := control.NewClient(t.socket)
+	if _, err := client.Info(); err != nil {
+		if control.Unreachable(err) {
+			return t, nil
+		}
+		return nil, err
+	}
+	t.client = client
+	return t, nil
+}
+
+// controlSocket 返回要连接的控制套接字：参数指定的、配置中的或默认的
+func controlSocket(cfg *config.Config, socket string) string {
+	if socket == "" && cfg != nil && cfg.Control != nil {
+		socket = cfg.Control.Socket
+	}
+	if socket == "" {
+		socket = control.DefaultSocket
+	}
+	return socket
+}
+
+func (t *target) online() bool {
+	return t.client != nil
+}
+
+// warnOffline 提示将直接修改配置文件：如果 gopf 实际在运行但监听了其他套接字，
+// 修改要等它重新加载配置后才生效
+func (t *target) warnOffline() {
+	fmt.Fprintf(os.Stderr, "注意: 无法连接控制套接字 %s，未找到运行中的 gopf，直接修改配置文件 %s\n",
+		t.socket, t.cfg.Path())
+}
+
+func (t *target) rules() ([]control.Rule, error) {
+	if t.online() {
+		return t.client.Rules()
+	}
+	// 离线时只有配置，没有运行状态
+	rules := make([]control.Rule, len(t.cfg.Rules))
+	for i := range t.cfg.Rules {
+		rules[i] = control.RuleOf(forwarder.RuleStatus{ForwardRule: t.cfg.Rules[i]})
+	}
+	return rules, nil
+}
+
+func (t *target) index(name string) (int, error) {
+	for i := range t.cfg.Rules {
+		if t.cfg.Rules[i].Name == name {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("%w: %s", control.ErrNotFound, name)
+}
+
+// ruleName 读取子命令的规则名称参数
+func ruleName(fs *flag.FlagSet) (string, error) {
+	if fs.NArg() != 1 {
+		return "", fmt.Errorf("用法: gopf %s [参数] <规则名称>", fs.Name())
+	}
+	return fs.Arg(0), nil
+}
+
+// runCommand 分发规则管理子命令
+func runCommand(cmd string, args []string) error {
+	switch cmd {
+	case "list", "ls":
+		return runList(args)
+	case "add":
+		return runAdd(args)
+	case "rm":
+		return runRemove(args)
+	case "start", "stop":
+		return runStartStop(cmd, args)
+	case "stats":
+		return runStats(args)
+	}
+	return fmt.Errorf("未知的命令: %s", cmd)
+}
+
+// runList 处理 list 子命令：列出规则及运行状态
+func runList(args []string) error {
+	var c cliFlags
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	c.register(fs)
+	fs.Parse(args)
+
+	format, err := c.format()
+	if err != nil {
+		return err
+	}
+	t, err := c.connect(false)
+	if err != nil {
+		return err
+	}
+	rules, err := t.rules()
+	if err != nil {
+		return err
+	}
+
+	return printOutput(format, rules, func(w io.Writer) {
+		fmt.Fprintln(w, "NAME\tTYPE\tLOCAL\tTARGET\tSTATUS\tCONNS\tSENT\tRECV")
+		for _, r := range rules {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%d\t%d\t%d\n",
+				r.Config.Name, r.Config.Kind(), r.Config.LocalPort, ruleTarget(&r.Config.ForwardRule),
+				ruleState(r, t.online()), r.Status.Connections, r.Status.BytesSent, r.Status.BytesRecv)
+		}
+	})
+}
+
+func ruleTarget(r *config.ForwardRule) string {
+	if r.Kind() == config.RuleRelay {
+		return "-"
+	}
+	return net.JoinHostPort(r.RemoteHost, strconv.Itoa(r.RemotePort))
+}
+
+func ruleState(r control.Rule, online bool) string {
+	switch {
+	case r.Status.Error != "":
+		return "error: " + r.Status.Error
+	case r.Status.Running:
+		return "running"
+	case r.Config.Disabled:
+		return "disabled"
+	case !online:
+		return "-"
+	}
+	return "stopped"
+}
+
+// runAdd 处理 add 子命令：添加规则，运行中的 gopf 会立即启动它
+func runAdd(args []string) error {
+	var c cliFlags
+	var rule config.ForwardRule
+	var remote string
+	fs := flag.NewFlagSet("add", flag.ExitOnError)
+	c.register(fs)
+	fs.StringVar(&rule.Name, "name", "", "规则名称")
+	fs.StringVar(&rule.Type, "type", "", "规则类型: forward（默认）、relay 或 reverse")
+	fs.IntVar(&rule.LocalPort, "local", 0, "本地端口")
+	fs.StringVar(&remote, "remote", "", "远程地址 host:port，reverse 规则为中继地址")
+	fs.IntVar(&rule.PublicPort, "public", 0, "reverse 规则在中继上开放的公网端口")
+	fs.StringVar(&rule.PublicPorts, "public-ports", "", "relay 规则允许开放的公网端口范围，例如 8000-8100")
+	fs.StringVar(&rule.Token, "rule-token", "", "relay/reverse 规则的认证令牌，relay 规则必须设置")
+	start := fs.Bool("start", true, "添加后立即启动，为 false 时规则保存为停用")
+	fs.Parse(args)
+
+	if remote != "" {
+		host, port, err := net.SplitHostPort(remote)
+		if err != nil {
+			return fmt.Errorf("远程地址无效: %v", err)
+		}
+		rule.RemoteHost = host
+		if rule.RemotePort, err = strconv.Atoi(port); err != nil {
+			return fmt.Errorf("远程端口无效: %s", port)
+		}
+	}
+	if err := control.Validate(&rule); err != nil {
+		return err
+	}
+
+	t, err := c.connect(true)
+	if err != nil {
+		return err
+	}
+	if t.online() {
+		err = t.client.AddRule(rule, *start)
+	} else {
+		t.warnOffline()
+		rule.Disabled = !*start
+		err = t.cfg.AddRule(rule)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("已添加规则 %s\n", rule.Name)
+	return nil
+}
+
+// runRemove 处理 rm 子命令：删除规则
+func runRemove(args []string) error {
+	var c cliFlags
+	fs := flag.NewFlagSet("rm", flag.ExitOnError)
+	c.register(fs)
+	fs.Parse(args)
+
+	name, err := ruleName(fs)
+	if err != nil {
+		return err
+	}
+	t, err := c.connect(false)
+	if err != nil {
+		return err
+	}
+	if t.online() {
+		err = t.client.DeleteRule(name)
+	} else {
+		t.warnOffline()
+		var idx int
+		if idx, err = t.index(name); err == nil {
+			err = t.cfg.DeleteRule(idx)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("已删除规则 %s\n", name)
+	return nil
+}
+
+// runStartStop 处理 start/stop 子命令：启停运行中的规则并保存为启用或停用，
+// 没有运行中的 gopf 时只修改配置文件，下次启动时生效
+func runStartStop(cmd string, args []string) error {
+	var c cliFlags
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	c.register(fs)
+	fs.Parse(args)
+
+	name, err := ruleName(fs)
+	if err != nil {
+		return err
+	}
+	t, err := c.connect(false)
+	if err != nil {
+		return err
+	}
+	if !t.online() {
+		idx, err := t.index(name)
+		if err != nil {
+			return err
+		}
+		t.warnOffline()
+		if err := t.cfg.SetDisabled(idx, cmd == "stop"); err != nil {
+			return err
+		}
+		if cmd == "start" {
+			fmt.Printf("%s: 已启用，gopf 启动时运行\n", name)
+		} else {
+			fmt.Printf("%s: 已停用，gopf 启动时不运行\n", name)
+		}
+		return nil
+	}
+
+	if cmd == "start" {
+		err = t.client.StartRule(name)
+	} else {
+		err = t.client.StopRule(name)
+	}
+	if err != nil {
+		return err
+	}
+	rule, err := t.client.Rule(name)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s: %s\n", name, ruleState(rule, true))
+	return nil
+}
+
+// ruleStats stats 子命令输出的一条规则的统计
+type ruleStats struct {
+	Name     string          `json:"name"`
+	Running  bool            `json:"running"`
+	Session  *control.Status `json:"session,omitempty"`  // 运行中实例的本次会话统计
+	Lifetime *lifetimeStats  `json:"lifetime,omitempty"` // 配置了 stats 时的累计统计
+}
+
+type lifetimeStats struct {
+	Total   stats.Counters `json:"total"`
+	Today   stats.Counters `json:"today"`
+	Month   stats.Counters `json:"month"`
+	ResetAt time.Time      `json:"reset_at"`
+}
+
+// runStats 处理 stats 子命令：输出运行中实例的实时统计，以及统计文件中的累计统计
+func runStats(args []string) error {
+	var c cliFlags
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	c.register(fs)
+	fs.Parse(args)
+
+	format, err := c.format()
+	if err != nil {
+		return err
+	}
+	t, err := c.connect(false)
+	if err != nil {
+		return err
+	}
+	rules, err := t.rules()
+	if err != nil {
+		return err
+	}
+
+	// 累计统计由运行中的实例定期写入文件，可能有最多一个写入间隔的延迟
+	var store *stats.Store
+	if t.cfg != nil && t.cfg.Stats != nil {
+		file := t.cfg.Stats.File
+		if file == "" {
+			file = stats.DefaultFile
+		}
+		if store, err = stats.Open(file); err != nil {
+			return err
+		}
+		migrateStats(store, t.cfg)
+	}
+
+	now := time.Now()
+	out := make([]ruleStats, 0, len(rules))
+	for _, r := range rules {
+		if fs.NArg() > 0 && r.Config.Name != fs.Arg(0) {
+			continue
+		}
+		s := ruleStats{Name: r.Config.Name, Running: r.Status.Running}
+		if t.online() {
+			status := r.Status
+			s.Session = &status
+		}
+		if store != nil {
+			rs := store.Get(r.Config.ID)
+			s.Lifetime = &lifetimeStats{Total: rs.Total, Today: rs.Day(now), Month: rs.Month(now), ResetAt: rs.ResetAt}
+		}
+		out = append(out, s)
+	}
+
+	return printOutput(format, out, func(w io.Writer) {
+		fmt.Fprintln(w, "NAME\tACTIVE\tCONNS\tSENT\tRECV\tRATE_SENT\tRATE_RECV\tDIAL_FAIL\tTOTAL_SENT\tTOTAL_RECV")
+		for _, s := range out {
+			session, lifetime := "-\t-\t-\t-\t-\t-\t-", "-\t-"
+			if s.Session != nil {
+				session = fmt.Sprintf("%d\t%d\t%d\t%d\t%d/s\t%d/s\t%d",
+					s.Session.Connections, s.Session.TotalConns, s.Session.BytesSent, s.Session.BytesRecv,
+					s.Session.RateSent, s.Session.RateRecv, s.Session.DialFailures)
+			}
+			if s.Lifetime != nil {
+				lifetime = fmt.Sprintf("%d\t%d", s.Lifetime.Total.BytesSent, s.Lifetime.Total.BytesRecv)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", s.Name, session, lifetime)
+		}
+	})
+}
+
+// printOutput 按格式输出 v，table 格式由 table 写出
+func printOutput(format string, v any, table func(w io.Writer)) error {
+	switch format {
+	case outputJSON:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case outputYAML:
+		// 经由 JSON 转换，使 YAML 的键名与 JSON 输出一致
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		var generic any
+		if err := json.Unmarshal(data, &generic); err != nil {
+			return err
+		}
+		out, err := yaml.Marshal(generic)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(out)
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	table(tw)
+	return tw.Flush()
+}
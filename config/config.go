@@ -1,8 +1,15 @@
 package config
 
 import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -14,27 +21,194 @@ const (
 	English Language = "en"
 )
 
+// 规则类型
+const (
+	RuleForward = "forward" // 本地监听，转发到远程（默认）
+	RuleRelay   = "relay"   // 中继：接受 reverse 规则注册并开放公网端口
+	RuleReverse = "reverse" // 反向转发：经中继将公网连接转回本地服务
+)
+
+// 超出配额后的处理方式
+const (
+	QuotaStop     = "stop"     // 断开现有连接并拒绝新连接（默认）
+	QuotaThrottle = "throttle" // 限制带宽继续转发
+)
+
+// 压缩方式，仅适用于两端均为 gopf 的链路
+const (
+	CompressLocal  = "local"  // 接入的客户端连接来自另一个 gopf 且已压缩
+	CompressRemote = "remote" // 连接的远程端是另一个 gopf，发往远程的数据需要压缩
+)
+
 type ForwardRule struct {
-	Name         string `yaml:"name"`
-	LocalPort    int    `yaml:"local_port"`
-	RemoteHost   string `yaml:"remote_host"`
-	RemotePort   int    `yaml:"remote_port"`
-	BytesSent    uint64 `yaml:"-"`
-	BytesRecv    uint64 `yaml:"-"`
-	Connections  uint64 `yaml:"-"`
-	ForwardCount uint64 `yaml:"-"`
-	Status       string `yaml:"-"`
-	Error        string `yaml:"-"`
-	IsRunning    bool   `yaml:"-"`
-	LastActive   int64  `yaml:"-"`
+	ID          string         `yaml:"id,omitempty"` // 规则的唯一标识，缺少时自动生成
+	Name        string         `yaml:"name"`
+	Type        string         `yaml:"type,omitempty"`
+	LocalPort   int            `yaml:"local_port"`
+	RemoteHost  string         `yaml:"remote_host,omitempty"`
+	RemotePort  int            `yaml:"remote_port,omitempty"`
+	PublicPort  int            `yaml:"public_port,omitempty"`
+	PublicPorts string         `yaml:"public_ports,omitempty"` // relay 规则允许开放的公网端口，如 "8000-8100"
+	Token       string         `yaml:"token,omitempty"`
+	Compress    string         `yaml:"compress,omitempty"`
+	Mirror      string         `yaml:"mirror,omitempty"`
+	Record      string         `yaml:"record,omitempty"`
+	Capture     *CaptureConfig `yaml:"capture,omitempty"`
+	Chaos       *ChaosConfig   `yaml:"chaos,omitempty"`
+	Quota       *QuotaConfig   `yaml:"quota,omitempty"`
+	LogLevel    string         `yaml:"log_level,omitempty"`
+	Disabled    bool           `yaml:"disabled,omitempty"` // 停用的规则保留在配置中，gopf 启动时不运行
+
+	generatedID bool // ID 是加载时生成的，配置文件中没有
+}
+
+// CaptureConfig 抓包设置，未配置时使用默认值，可在界面中随时开关
+type CaptureConfig struct {
+	Dir         string        `yaml:"dir,omitempty"`          // 抓包文件目录，默认 captures
+	MaxSize     int64         `yaml:"max_size,omitempty"`     // 单个文件最大字节数，默认 100MB
+	MaxDuration time.Duration `yaml:"max_duration,omitempty"` // 单个文件最长时长，默认 10m
+	Enabled     bool          `yaml:"enabled,omitempty"`      // 规则启动时即开始抓包
+}
+
+// ChaosConfig 故障注入设置，作用于每个转发的数据块，可在界面中随时开关
+type ChaosConfig struct {
+	Latency      time.Duration `yaml:"latency,omitempty"`       // 固定延迟
+	Jitter       time.Duration `yaml:"jitter,omitempty"`        // 额外随机延迟的上限
+	Bandwidth    int64         `yaml:"bandwidth,omitempty"`     // 每个连接每个方向的带宽上限（字节/秒）
+	ResetRate    float64       `yaml:"reset_rate,omitempty"`    // 以 RST 重置连接的概率
+	CorruptRate  float64       `yaml:"corrupt_rate,omitempty"`  // 随机篡改一个字节的概率
+	TruncateRate float64       `yaml:"truncate_rate,omitempty"` // 截断数据块并关闭连接的概率
+	Enabled      bool          `yaml:"enabled,omitempty"`       // 规则启动时即开启
+}
+
+// QuotaConfig 流量配额，按自然日和自然月计算，超出后在下一个周期开始时自动恢复
+type QuotaConfig struct {
+	Daily       uint64 `yaml:"daily,omitempty"`       // 每日流量上限（字节，双向合计）
+	Monthly     uint64 `yaml:"monthly,omitempty"`     // 每月流量上限（字节，双向合计）
+	Connections uint64 `yaml:"connections,omitempty"` // 每日连接数上限
+	Action      string `yaml:"action,omitempty"`      // stop（默认）或 throttle
+	Throttle    int64  `yaml:"throttle,omitempty"`    // throttle 时每个连接每个方向的带宽（字节/秒），默认 16KB/s
+}
+
+// Kind 返回规则类型，未设置时视为 forward
+func (r *ForwardRule) Kind() string {
+	if r.Type == "" {
+		return RuleForward
+	}
+	return r.Type
+}
+
+// PublicPortRange 解析 relay 规则允许开放的公网端口范围，格式为 "8000-8100" 或单个端口
+func (r *ForwardRule) PublicPortRange() (lo, hi int, err error) {
+	if r.PublicPorts == "" {
+		return 0, 0, fmt.Errorf("relay 规则必须设置 public_ports")
+	}
+	from, to, found := strings.Cut(r.PublicPorts, "-")
+	lo, err = strconv.Atoi(strings.TrimSpace(from))
+	hi = lo
+	if err == nil && found {
+		hi, err = strconv.Atoi(strings.TrimSpace(to))
+	}
+	if err != nil || lo < 1 || hi > 65535 || lo > hi {
+		return 0, 0, fmt.Errorf("无效的公网端口范围: %s", r.PublicPorts)
+	}
+	return lo, hi, nil
+}
+
+// CheckRelay 检查 relay 规则的令牌和公网端口范围，缺少任一项的中继会替任何人开放端口
+func (r *ForwardRule) CheckRelay() error {
+	if r.Token == "" {
+		return fmt.Errorf("relay 规则必须设置 token")
+	}
+	_, _, err := r.PublicPortRange()
+	return err
+}
+
+// MetricsConfig Prometheus 指标接口设置
+type MetricsConfig struct {
+	Listen string `yaml:"listen,omitempty"` // 监听地址，默认 127.0.0.1:9469
+	Path   string `yaml:"path,omitempty"`   // 指标路径，默认 /metrics
+}
+
+// StatsConfig 累计统计设置，配置后统计数据在重启后依然保留
+type StatsConfig struct {
+	File          string        `yaml:"file,omitempty"`           // 状态文件路径，默认 gopf-stats.json
+	FlushInterval time.Duration `yaml:"flush_interval,omitempty"` // 写入间隔，默认 1m
+}
+
+// LogConfig 日志设置，未配置文件时日志只在界面的日志面板中查看
+type LogConfig struct {
+	Level      string `yaml:"level,omitempty"`       // debug、info（默认）、warn 或 error
+	Format     string `yaml:"format,omitempty"`      // text（默认）或 json
+	File       string `yaml:"file,omitempty"`        // 日志文件路径
+	MaxSize    int64  `yaml:"max_size,omitempty"`    // 单个文件最大字节数，默认 10MB
+	MaxBackups *int   `yaml:"max_backups,omitempty"` // 保留的旧文件数，默认 3，为 0 时写满后清空当前文件
+}
+
+// AuditConfig 审计日志设置，每个会话关闭时追加一条记录
+type AuditConfig struct {
+	File   string `yaml:"file,omitempty"`   // 审计文件路径，默认 gopf-audit.jsonl
+	Format string `yaml:"format,omitempty"` // jsonl 或 csv，默认按扩展名判断
+}
+
+// HookConfig 事件钩子，事件发生时 POST JSON 到 URL 或执行本地命令
+type HookConfig struct {
+	Events  []string          `yaml:"events,omitempty"`  // 订阅的事件，为空时订阅除连接事件外的全部事件
+	Rules   []string          `yaml:"rules,omitempty"`   // 只处理这些规则的事件，为空表示全部规则
+	URL     string            `yaml:"url,omitempty"`     // Webhook 地址
+	Headers map[string]string `yaml:"headers,omitempty"` // Webhook 附加的请求头
+	Command string            `yaml:"command,omitempty"` // 本地命令，事件数据通过环境变量传入
+	Timeout time.Duration     `yaml:"timeout,omitempty"` // 单次执行超时，默认 10s
+}
+
+// ControlConfig 控制接口设置，供脚本和编辑器管理运行中的 gopf
+type ControlConfig struct {
+	Socket string `yaml:"socket,omitempty"` // Unix 套接字路径，默认 gopf.sock
+	Listen string `yaml:"listen,omitempty"` // 额外的 TCP 监听地址，需同时设置 token
+	Token  string `yaml:"token,omitempty"`  // TCP 访问令牌，通过 Authorization: Bearer 传入
+}
+
+// HostConfig 界面连接的 gopf 实例，socket 和 server 二选一
+type HostConfig struct {
+	Name   string `yaml:"name"`
+	Socket string `yaml:"socket,omitempty"` // 本机的控制套接字
+	Server string `yaml:"server,omitempty"` // 远程实例的 TCP 地址，例如 10.0.0.2:9470
+	Token  string `yaml:"token,omitempty"`  // TCP 访问令牌
+}
+
+// WatchConfig 配置文件变化检测，默认开启
+type WatchConfig struct {
+	Disabled bool          `yaml:"disabled,omitempty"`
+	Interval time.Duration `yaml:"interval,omitempty"` // 检查间隔，默认 2s
 }
 
 type Config struct {
-	Rules      []ForwardRule `yaml:"rules"`
-	configPath string        `yaml:"-"`
+	Rules      []ForwardRule  `yaml:"rules"`
+	Metrics    *MetricsConfig `yaml:"metrics,omitempty"`
+	Stats      *StatsConfig   `yaml:"stats,omitempty"`
+	Log        *LogConfig     `yaml:"log,omitempty"`
+	Audit      *AuditConfig   `yaml:"audit,omitempty"`
+	Hooks      []HookConfig   `yaml:"hooks,omitempty"`
+	Control    *ControlConfig `yaml:"control,omitempty"`
+	Hosts      []HostConfig   `yaml:"hosts,omitempty"` // gopf ui -attach 连接的实例
+	Watch      *WatchConfig   `yaml:"watch,omitempty"`
+	configPath string         `yaml:"-"`
 }
 
+// LoadConfig 读取配置文件，并写回为缺少 ID 的规则生成的 ID，使其在之后的运行中保持不变；
+// 写入失败时 ID 只在本次运行有效。只由运行规则的进程（界面和 gopf serve）调用，
+// 只读取配置的命令使用 ReadConfig，不改动文件。
 func LoadConfig(filename string) (*Config, error) {
+	config, err := ReadConfig(filename)
+	if err != nil {
+		return nil, err
+	}
+	config.SaveIDs()
+	return config, nil
+}
+
+// ReadConfig 读取配置文件，为缺少 ID 的规则生成 ID 但不写回，用于重新加载配置
+func ReadConfig(filename string) (*Config, error) {
 	data, err := os.ReadFile(filename)
 	if err != nil {
 		return nil, err
@@ -46,24 +220,181 @@ func LoadConfig(filename string) (*Config, error) {
 	}
 
 	config.configPath = filename
+	config.assignIDs()
 	return &config, nil
 }
 
+// NewRuleID 生成随机的规则 ID
+func NewRuleID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// assignIDs 为缺少 ID 或 ID 重复的规则生成新的 ID
+func (c *Config) assignIDs() {
+	seen := make(map[string]bool, len(c.Rules))
+	for i := range c.Rules {
+		r := &c.Rules[i]
+		if r.ID == "" || seen[r.ID] {
+			r.ID = c.newID()
+			r.generatedID = true
+		}
+		seen[r.ID] = true
+	}
+}
+
+func (c *Config) hasGeneratedIDs() bool {
+	for i := range c.Rules {
+		if c.Rules[i].generatedID {
+			return true
+		}
+	}
+	return false
+}
+
+// SaveIDs 将生成的规则 ID 写回配置文件。按位置对应文件中的规则补上 id，同名规则也各自
+// 保留自己的 ID；保留注释和其他内容，没有生成的 ID 时不写文件。读取后文件中的规则已被
+// 修改（数量或名称对不上）时不写文件，返回错误。
+func (c *Config) SaveIDs() error {
+	if !c.hasGeneratedIDs() {
+		return nil
+	}
+	if c.configPath == "" {
+		return fmt.Errorf("配置文件路径未设置")
+	}
+	data, err := os.ReadFile(c.configPath)
+	if err != nil {
+		return err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	var rules *yaml.Node
+	if len(doc.Content) > 0 {
+		rules = mappingValue(doc.Content[0], "rules")
+	}
+	if rules == nil || rules.Kind != yaml.SequenceNode {
+		return fmt.Errorf("配置文件中没有规则列表")
+	}
+
+	if len(rules.Content) != len(c.Rules) {
+		return fmt.Errorf("配置文件中的规则已变化，未写回规则 ID")
+	}
+	for i, item := range rules.Content {
+		var name string
+		if v := mappingValue(item, "name"); v != nil {
+			name = v.Value
+		}
+		if name != c.Rules[i].Name {
+			return fmt.Errorf("配置文件中的规则已变化，未写回规则 ID")
+		}
+	}
+	for i, item := range rules.Content {
+		if !c.Rules[i].generatedID {
+			continue
+		}
+		id := c.Rules[i].ID
+		// ID 重复的规则替换原有的 id，缺少的加在规则的第一行
+		if v := mappingValue(item, "id"); v != nil {
+			v.SetString(id)
+			continue
+		}
+		key, value := &yaml.Node{}, &yaml.Node{}
+		key.SetString("id")
+		value.SetString(id)
+		item.Content = append([]*yaml.Node{key, value}, item.Content...)
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	if err := writeFile(c.configPath, buf.Bytes()); err != nil {
+		return err
+	}
+	c.clearGeneratedIDs()
+	return nil
+}
+
+// mappingValue 返回映射节点中键 key 对应的值，不存在时返回 nil
+func mappingValue(n *yaml.Node, key string) *yaml.Node {
+	if n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func (c *Config) clearGeneratedIDs() {
+	for i := range c.Rules {
+		c.Rules[i].generatedID = false
+	}
+}
+
+// newID 生成一个与现有规则都不重复的 ID
+func (c *Config) newID() string {
+	for {
+		id := NewRuleID()
+		if c.RuleIndex(id) < 0 {
+			return id
+		}
+	}
+}
+
+// RuleIndex 返回指定 ID 的规则下标，不存在时返回 -1
+func (c *Config) RuleIndex(id string) int {
+	for i := range c.Rules {
+		if c.Rules[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// Path 返回加载配置时的文件路径
+func (c *Config) Path() string {
+	return c.configPath
+}
+
 func (c *Config) Save() error {
 	if c.configPath == "" {
 		return fmt.Errorf("配置文件路径未设置")
 	}
-	return SaveConfig(c.configPath, c)
+	if err := SaveConfig(c.configPath, c); err != nil {
+		return err
+	}
+	c.clearGeneratedIDs()
+	return nil
 }
 
+// AddRule 添加规则并保存，规则没有 ID 或 ID 已被使用时生成新的 ID
 func (c *Config) AddRule(rule ForwardRule) error {
-	// 检查端口是否已存在
+	// 检查名称和端口是否已存在
 	for _, r := range c.Rules {
+		if r.Name == rule.Name {
+			return fmt.Errorf("规则名称 '%s' 已存在", rule.Name)
+		}
 		if r.LocalPort == rule.LocalPort {
 			return fmt.Errorf("本地端口 %d 已被使用", rule.LocalPort)
 		}
 	}
 
+	if rule.ID == "" || c.RuleIndex(rule.ID) >= 0 {
+		rule.ID = c.newID()
+	}
+	rule.generatedID = false
 	c.Rules = append(c.Rules, rule)
 	return c.Save()
 }
@@ -73,17 +404,38 @@ func (c *Config) UpdateRule(index int, rule ForwardRule) error {
 		return fmt.Errorf("规则索引越界")
 	}
 
-	// 检查端口是否已被其他规则使用
+	// 检查名称和端口是否已被其他规则使用
 	for i, r := range c.Rules {
-		if i != index && r.LocalPort == rule.LocalPort {
+		if i == index {
+			continue
+		}
+		if r.Name == rule.Name {
+			return fmt.Errorf("规则名称 '%s' 已存在", rule.Name)
+		}
+		if r.LocalPort == rule.LocalPort {
 			return fmt.Errorf("本地端口 %d 已被规则 '%s' 使用", rule.LocalPort, r.Name)
 		}
 	}
 
+	// 修改后仍是同一条规则，沿用原来的 ID
+	rule.ID = c.Rules[index].ID
+	rule.generatedID = false
 	c.Rules[index] = rule
 	return c.Save()
 }
 
+// SetDisabled 设置规则是否停用并保存，没有变化时不写文件
+func (c *Config) SetDisabled(index int, disabled bool) error {
+	if index < 0 || index >= len(c.Rules) {
+		return fmt.Errorf("规则索引越界")
+	}
+	if c.Rules[index].Disabled == disabled {
+		return nil
+	}
+	c.Rules[index].Disabled = disabled
+	return c.Save()
+}
+
 func (c *Config) DeleteRule(index int) error {
 	if index < 0 || index >= len(c.Rules) {
 		return fmt.Errorf("规则索引越界")
@@ -100,5 +452,37 @@ func SaveConfig(filename string, config *Config) error {
 		return err
 	}
 
-	return os.WriteFile(filename, data, 0644)
+	return writeFile(filename, data)
+}
+
+// writeFile 先写入同目录下的临时文件再重命名，写入中途失败不会留下不完整的配置
+func writeFile(filename string, data []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(filename); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
 }
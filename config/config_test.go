@@ -0,0 +1,219 @@
+package config_test
+
+import (
+	"gopf/config"
+	"gopf/forwarder"
+	"gopf/stats"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newConfig 在临时目录中创建配置文件并加载
+func newConfig(t *testing.T, rules ...config.ForwardRule) *config.Config {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "gopf.yaml")
+	if err := config.SaveConfig(file, &config.Config{Rules: rules}); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.LoadConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func freePort(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestDuplicateNameRejected(t *testing.T) {
+	cfg := newConfig(t,
+		config.ForwardRule{Name: "a", LocalPort: 18001, RemoteHost: "127.0.0.1", RemotePort: 1},
+		config.ForwardRule{Name: "b", LocalPort: 18002, RemoteHost: "127.0.0.1", RemotePort: 1},
+	)
+
+	if err := cfg.AddRule(config.ForwardRule{Name: "a", LocalPort: 18003, RemoteHost: "127.0.0.1", RemotePort: 1}); err == nil {
+		t.Error("AddRule 接受了重复的名称")
+	}
+	rule := cfg.Rules[1]
+	rule.Name = "a"
+	if err := cfg.UpdateRule(1, rule); err == nil {
+		t.Error("UpdateRule 接受了重复的名称")
+	}
+	if len(cfg.Rules) != 2 || cfg.Rules[1].Name != "b" {
+		t.Errorf("拒绝后配置被修改: %+v", cfg.Rules)
+	}
+
+	// 配置文件同样保持不变
+	saved, err := config.ReadConfig(cfg.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.Rules) != 2 || saved.Rules[0].Name != "a" || saved.Rules[1].Name != "b" {
+		t.Errorf("拒绝后配置文件被修改: %+v", saved.Rules)
+	}
+}
+
+// 手工编辑出的同名规则各自写回自己的 ID
+func TestSaveIDsDuplicateNames(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "gopf.yaml")
+	data := `rules:
+  # 第一条
+  - name: dup
+    local_port: 18001
+    remote_host: 127.0.0.1
+    remote_port: 1
+  - name: dup
+    local_port: 18002
+    remote_host: 127.0.0.1
+    remote_port: 1
+`
+	if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := config.LoadConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Rules[0].ID == cfg.Rules[1].ID {
+		t.Fatalf("同名规则的 ID 相同: %s", cfg.Rules[0].ID)
+	}
+
+	saved, err := config.ReadConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range cfg.Rules {
+		if saved.Rules[i].ID != cfg.Rules[i].ID {
+			t.Errorf("规则 %d 保存的 ID 为 %s，期望 %s", i, saved.Rules[i].ID, cfg.Rules[i].ID)
+		}
+	}
+}
+
+func TestRenameRunningRule(t *testing.T) {
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+	go func() {
+		for {
+			conn, err := upstream.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	oldPort := freePort(t)
+	cfg := newConfig(t, config.ForwardRule{
+		Name:       "old",
+		LocalPort:  oldPort,
+		RemoteHost: "127.0.0.1",
+		RemotePort: upstream.Addr().(*net.TCPAddr).Port,
+	})
+	id := cfg.Rules[0].ID
+
+	store, err := stats.Open(filepath.Join(t.TempDir(), "stats.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mgr := forwarder.NewManager(&forwarder.Env{Stats: store})
+	defer mgr.StopAll()
+	if err := mgr.Sync(cfg.Rules); err != nil {
+		t.Fatal(err)
+	}
+
+	// 产生一些流量并计入累计统计
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(oldPort)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := []byte("hello")
+	conn.Write(payload)
+	io.ReadFull(conn, make([]byte, len(payload)))
+	conn.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s, _ := mgr.Status(id)
+		if s.BytesSent == uint64(len(payload)) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("统计未同步: %+v", s.Stats)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	mgr.RecordStats()
+	before := store.Get(id).Total
+	if before.BytesSent != uint64(len(payload)) {
+		t.Fatalf("累计统计 %+v", before)
+	}
+	oldFwd := mgr.Forwarder(id)
+
+	// 改名并换端口，规则按 ID 对应，应原地重启
+	rule := cfg.Rules[0]
+	rule.Name = "new"
+	rule.LocalPort = freePort(t)
+	if err := cfg.UpdateRule(0, rule); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.Put(cfg.Rules[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Rules[0].ID != id {
+		t.Errorf("改名后 ID 变为 %s，期望 %s", cfg.Rules[0].ID, id)
+	}
+	if list := mgr.Snapshot(); len(list) != 1 || list[0].ID != id || list[0].Name != "new" {
+		t.Errorf("改名后的规则列表: %+v", list)
+	}
+	if n := mgr.Running(); n != 1 {
+		t.Errorf("运行中的转发器 %d 个，期望 1 个", n)
+	}
+	if f := mgr.Forwarder(id); f == nil || f == oldFwd {
+		t.Error("改名后没有用新配置重启转发器")
+	}
+
+	// 原端口已释放
+	ln, err := net.Listen("tcp", net.JoinHostPort("", strconv.Itoa(oldPort)))
+	if err != nil {
+		t.Errorf("原端口 %d 未释放: %v", oldPort, err)
+	} else {
+		ln.Close()
+	}
+
+	// 累计统计仍在原 ID 下，没有留在旧名称下
+	mgr.RecordStats()
+	if got := store.Get(id).Total; got != before {
+		t.Errorf("改名后累计统计 %+v，期望 %+v", got, before)
+	}
+	if got := store.Get("old").Total; got != (stats.Counters{}) {
+		t.Errorf("旧名称下残留统计 %+v", got)
+	}
+
+	// 配置文件中保存的也是同一个 ID
+	saved, err := config.ReadConfig(cfg.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Rules[0].ID != id || saved.Rules[0].Name != "new" {
+		t.Errorf("保存的规则: %+v", saved.Rules[0])
+	}
+}
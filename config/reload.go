@@ -0,0 +1,145 @@
+package config
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultWatchInterval 检查配置文件变化的默认间隔
+const DefaultWatchInterval = 2 * time.Second
+
+// RuleDiff 重新加载配置时规则的变化，规则按 ID 对应，列表中均为规则 ID
+type RuleDiff struct {
+	Added   []string
+	Removed []string
+	Changed []string
+	NewIDs  bool // 有规则在加载时生成了 ID，需要调用 SaveIDs 写回文件
+}
+
+func (d RuleDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// SameConfig 判断两条规则的配置是否相同，忽略运行状态和统计
+func (r *ForwardRule) SameConfig(o *ForwardRule) bool {
+	a, errA := yaml.Marshal(r)
+	b, errB := yaml.Marshal(o)
+	return errA == nil && errB == nil && bytes.Equal(a, b)
+}
+
+// DiffRules 比较当前规则和新读取的规则
+func DiffRules(old, next []ForwardRule) RuleDiff {
+	var d RuleDiff
+	prev := make(map[string]*ForwardRule, len(old))
+	for i := range old {
+		prev[old[i].ID] = &old[i]
+	}
+
+	seen := make(map[string]bool, len(next))
+	for i := range next {
+		r := &next[i]
+		seen[r.ID] = true
+		o, ok := prev[r.ID]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, r.ID)
+		case !o.SameConfig(r):
+			d.Changed = append(d.Changed, r.ID)
+		}
+	}
+	for i := range old {
+		if !seen[old[i].ID] {
+			d.Removed = append(d.Removed, old[i].ID)
+		}
+	}
+	return d
+}
+
+// adoptIDs 配置文件中没有 ID 的规则在加载时生成了新 ID（未能写回文件），
+// 按名称对应到当前规则并沿用其 ID，避免每次重新加载都被当作新规则
+func adoptIDs(old, next []ForwardRule) {
+	taken := make(map[string]bool, len(next))
+	for i := range next {
+		if !next[i].generatedID {
+			taken[next[i].ID] = true
+		}
+	}
+	byName := make(map[string]string, len(old))
+	for i := range old {
+		if !taken[old[i].ID] {
+			byName[old[i].Name] = old[i].ID
+		}
+	}
+	for i := range next {
+		r := &next[i]
+		if id, ok := byName[r.Name]; ok && r.generatedID {
+			r.ID = id
+			delete(byName, r.Name)
+		}
+	}
+}
+
+// Watch 定期检查配置文件，内容变化时调用 onChange，直到 stop 关闭。
+// 按内容而非修改时间判断，gopf 自己保存的配置重新加载后没有变化，不会影响运行中的规则。
+func Watch(filename string, interval time.Duration, stop <-chan struct{}, onChange func()) {
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+	sum := fileSum(filename)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			cur := fileSum(filename)
+			if cur == sum {
+				continue
+			}
+			sum = cur
+			onChange()
+		}
+	}
+}
+
+// fileSum 返回文件内容的摘要，读取失败时返回零值
+func fileSum(filename string) [sha256.Size]byte {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return [sha256.Size]byte{}
+	}
+	return sha256.Sum256(data)
+}
+
+// WatchInterval 返回检查配置文件的间隔，关闭检测时返回 0
+func (c *Config) WatchInterval() time.Duration {
+	switch {
+	case c.Watch == nil:
+		return DefaultWatchInterval
+	case c.Watch.Disabled:
+		return 0
+	case c.Watch.Interval > 0:
+		return c.Watch.Interval
+	}
+	return DefaultWatchInterval
+}
+
+// Apply 用重新读取的配置替换当前配置，不保存配置文件，返回规则的变化。
+// 规则按 ID 比较，由调用方据此启停规则（forwarder.Manager.Sync）；
+// 其他设置只更新内容，使之后保存配置时不会覆盖文件中的修改，需要重启才生效。
+func (c *Config) Apply(next *Config) RuleDiff {
+	adoptIDs(c.Rules, next.Rules)
+	d := DiffRules(c.Rules, next.Rules)
+	d.NewIDs = next.hasGeneratedIDs()
+
+	path := c.configPath
+	*c = *next
+	c.configPath = path
+	return d
+}
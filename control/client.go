@@ -0,0 +1,217 @@
+package control
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"gopf/config"
+	"gopf/hooks"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Client 访问运行中 gopf 的控制接口
+type Client struct {
+	base   string
+	token  string
+	http   *http.Client
+	stream *http.Client // 事件流没有超时
+}
+
+// NewClient 通过 Unix 套接字连接本机的 gopf
+func NewClient(socket string) *Client {
+	dial := func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", socket)
+	}
+	return newClient("http://gopf", "", &http.Transport{DialContext: dial})
+}
+
+// NewTCPClient 通过 TCP 连接 gopf，addr 为 host:port
+func NewTCPClient(addr, token string) *Client {
+	return newClient("http://"+addr, token, &http.Transport{})
+}
+
+func newClient(base, token string, tr *http.Transport) *Client {
+	return &Client{
+		base:   base,
+		token:  token,
+		http:   &http.Client{Transport: tr, Timeout: 10 * time.Second},
+		stream: &http.Client{Transport: tr},
+	}
+}
+
+// APIError 控制接口返回的错误
+type APIError struct {
+	Status  int
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// Is 使 errors.Is 可以用 ErrNotFound 和 ErrUnavailable 判断接口错误
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.Status == http.StatusNotFound
+	case ErrUnavailable:
+		return e.Status == http.StatusServiceUnavailable
+	}
+	return false
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, body any) (*http.Request, error) {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return req, nil
+}
+
+func (c *Client) do(method, path string, body, out any) error {
+	req, err := c.newRequest(context.Background(), method, path, body)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var e errorBody
+		if json.NewDecoder(resp.Body).Decode(&e) != nil || e.Error == "" {
+			e.Error = resp.Status
+		}
+		return &APIError{Status: resp.StatusCode, Message: e.Error}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func rulePath(name string) string {
+	return "/v1/rules/" + url.PathEscape(name)
+}
+
+func (c *Client) Info() (Info, error) {
+	var info Info
+	err := c.do(http.MethodGet, "/v1/info", nil, &info)
+	return info, err
+}
+
+func (c *Client) Rules() ([]Rule, error) {
+	var rules []Rule
+	err := c.do(http.MethodGet, "/v1/rules", nil, &rules)
+	return rules, err
+}
+
+func (c *Client) Rule(name string) (Rule, error) {
+	var rule Rule
+	err := c.do(http.MethodGet, rulePath(name), nil, &rule)
+	return rule, err
+}
+
+func (c *Client) AddRule(rule config.ForwardRule, start bool) error {
+	path := "/v1/rules"
+	if !start {
+		path += "?start=false"
+	}
+	return c.do(http.MethodPost, path, RuleConfig{rule}, nil)
+}
+
+func (c *Client) UpdateRule(name string, rule config.ForwardRule) error {
+	return c.do(http.MethodPut, rulePath(name), RuleConfig{rule}, nil)
+}
+
+func (c *Client) DeleteRule(name string) error {
+	return c.do(http.MethodDelete, rulePath(name), nil, nil)
+}
+
+func (c *Client) StartRule(name string) error {
+	return c.do(http.MethodPost, rulePath(name)+"/start", nil, nil)
+}
+
+func (c *Client) StopRule(name string) error {
+	return c.do(http.MethodPost, rulePath(name)+"/stop", nil, nil)
+}
+
+func (c *Client) ClearStats(name string, lifetime bool) error {
+	path := rulePath(name) + "/clear"
+	if lifetime {
+		path += "?lifetime=true"
+	}
+	return c.do(http.MethodPost, path, nil, nil)
+}
+
+// Events 订阅事件流，直到 ctx 取消或连接断开，之后返回的通道被关闭
+func (c *Client) Events(ctx context.Context) (<-chan hooks.Event, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, "/v1/events", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.stream.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, &APIError{Status: resp.StatusCode, Message: resp.Status}
+	}
+
+	ch := make(chan hooks.Event)
+	go func() {
+		defer close(ch)
+		defer resp.Body.Close()
+
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			var e hooks.Event
+			if json.Unmarshal(sc.Bytes(), &e) != nil {
+				continue
+			}
+			select {
+			case ch <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// Unreachable 判断错误是否表示没有可连接的 gopf（套接字不存在或无人监听）
+func Unreachable(err error) bool {
+	var apiErr *APIError
+	if err == nil || errors.As(err, &apiErr) {
+		return false
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// Client 同样实现了 Backend，可以用来操作另一个 gopf
+var _ Backend = (*Client)(nil)
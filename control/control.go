@@ -0,0 +1,132 @@
+// Package control 提供管理运行中 gopf 的控制接口。
+//
+// 接口为 JSON over HTTP，默认监听 Unix 套接字，也可以额外监听 TCP（需令牌认证）。
+// 规则配置使用与 gopf.yaml 相同的键名，运行状态与界面显示的统计一致：
+//
+//	GET    /v1/info                  版本与运行模式
+//	GET    /v1/rules                 列出规则及运行状态
+//	POST   /v1/rules?start=false     添加规则，默认立即启动
+//	GET    /v1/rules/{name}          查看规则
+//	PUT    /v1/rules/{name}          修改规则，运行中的规则会重启
+//	DELETE /v1/rules/{name}          删除规则
+//	POST   /v1/rules/{name}/start    启动规则
+//	POST   /v1/rules/{name}/stop     停止规则
+//	POST   /v1/rules/{name}/clear    清空统计，?lifetime=true 时清空累计统计
+//	GET    /v1/events                以 JSON Lines 持续推送事件
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gopf/config"
+	"gopf/forwarder"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	// ErrNotFound 指定名称的规则不存在
+	ErrNotFound = errors.New("规则不存在")
+	// ErrUnavailable 后端已退出，无法处理请求
+	ErrUnavailable = errors.New("gopf 正在退出")
+)
+
+// Backend 执行控制操作，由界面或无界面模式实现
+type Backend interface {
+	Rules() ([]Rule, error)
+	AddRule(rule config.ForwardRule, start bool) error
+	UpdateRule(name string, rule config.ForwardRule) error
+	DeleteRule(name string) error
+	StartRule(name string) error
+	StopRule(name string) error
+	ClearStats(name string, lifetime bool) error
+}
+
+// Info 运行中实例的基本信息
+type Info struct {
+	Version string `json:"version"`
+	Mode    string `json:"mode"` // ui 或 serve
+}
+
+// Rule 规则配置及其运行状态
+type Rule struct {
+	Config RuleConfig `json:"config"`
+	Status Status     `json:"status"`
+}
+
+// RuleConfig 以 gopf.yaml 中的键名编码规则配置，时长使用 "30s" 这样的字符串
+type RuleConfig struct {
+	config.ForwardRule
+}
+
+func (c RuleConfig) MarshalJSON() ([]byte, error) {
+	data, err := yaml.Marshal(&c.ForwardRule)
+	if err != nil {
+		return nil, err
+	}
+	var v map[string]any
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON 借助 YAML 解析（JSON 是 YAML 的子集），以便沿用配置文件的键名和时长格式
+func (c *RuleConfig) UnmarshalJSON(data []byte) error {
+	var rule config.ForwardRule
+	if err := yaml.Unmarshal(data, &rule); err != nil {
+		return err
+	}
+	c.ForwardRule = rule
+	return nil
+}
+
+// Status 规则的运行状态和统计
+type Status struct {
+	Running bool   `json:"running"`
+	Error   string `json:"error,omitempty"`
+	forwarder.Stats
+}
+
+// RuleOf 返回规则的配置和运行状态
+func RuleOf(s forwarder.RuleStatus) Rule {
+	return Rule{
+		Config: RuleConfig{s.ForwardRule},
+		Status: Status{Running: s.Running, Error: s.Error, Stats: s.Stats},
+	}
+}
+
+// Validate 检查通过接口提交的规则的基本字段
+func Validate(r *config.ForwardRule) error {
+	if r.Name == "" {
+		return fmt.Errorf("规则名称不能为空")
+	}
+	if r.LocalPort < 1 || r.LocalPort > 65535 {
+		return fmt.Errorf("本地端口无效: %d", r.LocalPort)
+	}
+	switch r.Kind() {
+	case config.RuleForward, config.RuleReverse:
+		if r.RemoteHost == "" {
+			return fmt.Errorf("远程主机不能为空")
+		}
+		if r.RemotePort < 1 || r.RemotePort > 65535 {
+			return fmt.Errorf("远程端口无效: %d", r.RemotePort)
+		}
+	case config.RuleRelay:
+		return r.CheckRelay()
+	default:
+		return fmt.Errorf("未知的规则类型: %s", r.Type)
+	}
+	return nil
+}
+
+// RuleStatus 将规则还原为 forwarder.RuleStatus，便于按本地规则的方式显示
+func (r Rule) RuleStatus() forwarder.RuleStatus {
+	return forwarder.RuleStatus{
+		ForwardRule: r.Config.ForwardRule,
+		Stats:       r.Status.Stats,
+		Running:     r.Status.Running,
+		Error:       r.Status.Error,
+	}
+}
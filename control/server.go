@@ -0,0 +1,291 @@
+package control
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gopf/config"
+	"gopf/hooks"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultSocket = "gopf.sock"
+
+	// 每个事件流订阅的缓冲大小，客户端读取不及时时丢弃事件
+	eventBuffer = 64
+)
+
+// Server 控制接口服务，nil 值可以安全使用（不做任何事）
+type Server struct {
+	socket    string
+	token     string
+	listeners []net.Listener
+	servers   []*http.Server
+}
+
+// Listen 按配置打开 Unix 套接字和可选的 TCP 监听，此时尚未开始处理请求。
+// 套接字文件已存在但无人监听时视为上次异常退出留下的文件，直接删除。
+func Listen(cfg *config.ControlConfig) (*Server, error) {
+	s := &Server{socket: cfg.Socket, token: cfg.Token}
+	if s.socket == "" {
+		s.socket = DefaultSocket
+	}
+	if cfg.Listen != "" && cfg.Token == "" {
+		return nil, fmt.Errorf("控制接口监听 TCP 时必须设置 token")
+	}
+
+	if _, err := os.Stat(s.socket); err == nil {
+		if c, err := net.Dial("unix", s.socket); err == nil {
+			c.Close()
+			return nil, fmt.Errorf("控制套接字 %s 已被其他 gopf 实例使用", s.socket)
+		}
+		os.Remove(s.socket)
+	}
+	ln, err := listenUnix(s.socket)
+	if err != nil {
+		return nil, fmt.Errorf("控制接口监听失败: %v", err)
+	}
+	s.listeners = append(s.listeners, ln)
+
+	if cfg.Listen != "" {
+		tcp, err := net.Listen("tcp", cfg.Listen)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("控制接口监听失败: %v", err)
+		}
+		s.listeners = append(s.listeners, tcp)
+	}
+	return s, nil
+}
+
+// Serve 开始在后台处理请求
+func (s *Server) Serve(b Backend, events *hooks.Dispatcher, info Info) {
+	if s == nil {
+		return
+	}
+	h := Handler(b, events, info)
+	for _, ln := range s.listeners {
+		handler := h
+		if ln.Addr().Network() == "tcp" {
+			handler = requireToken(s.token, h)
+		}
+		srv := &http.Server{Handler: handler}
+		s.servers = append(s.servers, srv)
+		go func() {
+			if err := srv.Serve(ln); err != nil && !errors.Is(err, net.ErrClosed) && err != http.ErrServerClosed {
+				log.Printf("控制接口异常退出: %v", err)
+			}
+		}()
+	}
+}
+
+// Close 停止监听、断开事件流等现有连接，并删除套接字文件
+func (s *Server) Close() error {
+	if s == nil {
+		return nil
+	}
+	for _, srv := range s.servers {
+		srv.Close()
+	}
+	for _, ln := range s.listeners {
+		ln.Close()
+	}
+	return os.Remove(s.socket)
+}
+
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 只接受 "Bearer <令牌>" 形式，不带认证方案的裸令牌同样拒绝
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			writeError(w, http.StatusUnauthorized, fmt.Errorf("令牌无效"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Handler 返回处理控制请求的 http.Handler
+func Handler(b Backend, events *hooks.Dispatcher, info Info) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /v1/info", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, info)
+	})
+
+	mux.HandleFunc("GET /v1/rules", func(w http.ResponseWriter, r *http.Request) {
+		rules, err := b.Rules()
+		if err != nil {
+			writeError(w, statusOf(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, rules)
+	})
+
+	mux.HandleFunc("GET /v1/rules/{name}", func(w http.ResponseWriter, r *http.Request) {
+		rule, err := find(b, r.PathValue("name"))
+		if err != nil {
+			writeError(w, statusOf(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, rule)
+	})
+
+	mux.HandleFunc("POST /v1/rules", func(w http.ResponseWriter, r *http.Request) {
+		rule, err := readRule(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		start := r.URL.Query().Get("start") != "false"
+		if err := b.AddRule(rule, start); err != nil {
+			writeError(w, statusOf(err), err)
+			return
+		}
+		respondRule(w, b, rule.Name, http.StatusCreated)
+	})
+
+	mux.HandleFunc("PUT /v1/rules/{name}", func(w http.ResponseWriter, r *http.Request) {
+		rule, err := readRule(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := b.UpdateRule(r.PathValue("name"), rule); err != nil {
+			writeError(w, statusOf(err), err)
+			return
+		}
+		respondRule(w, b, rule.Name, http.StatusOK)
+	})
+
+	mux.HandleFunc("DELETE /v1/rules/{name}", func(w http.ResponseWriter, r *http.Request) {
+		if err := b.DeleteRule(r.PathValue("name")); err != nil {
+			writeError(w, statusOf(err), err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	action := func(fn func(name string, r *http.Request) error) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			name := r.PathValue("name")
+			if err := fn(name, r); err != nil {
+				writeError(w, statusOf(err), err)
+				return
+			}
+			respondRule(w, b, name, http.StatusOK)
+		}
+	}
+	mux.HandleFunc("POST /v1/rules/{name}/start", action(func(name string, r *http.Request) error {
+		return b.StartRule(name)
+	}))
+	mux.HandleFunc("POST /v1/rules/{name}/stop", action(func(name string, r *http.Request) error {
+		return b.StopRule(name)
+	}))
+	mux.HandleFunc("POST /v1/rules/{name}/clear", action(func(name string, r *http.Request) error {
+		lifetime, _ := strconv.ParseBool(r.URL.Query().Get("lifetime"))
+		return b.ClearStats(name, lifetime)
+	}))
+
+	mux.HandleFunc("GET /v1/events", func(w http.ResponseWriter, r *http.Request) {
+		ch, cancel := events.Subscribe(eventBuffer)
+		defer cancel()
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		flusher, _ := w.(http.Flusher)
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		enc := json.NewEncoder(w)
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case e, ok := <-ch:
+				if !ok {
+					return
+				}
+				if err := enc.Encode(e); err != nil {
+					return
+				}
+				if flusher != nil {
+					flusher.Flush()
+				}
+			}
+		}
+	})
+
+	return mux
+}
+
+func find(b Backend, name string) (Rule, error) {
+	rules, err := b.Rules()
+	if err != nil {
+		return Rule{}, err
+	}
+	for _, r := range rules {
+		if r.Config.Name == name {
+			return r, nil
+		}
+	}
+	return Rule{}, ErrNotFound
+}
+
+// readRule 读取并校验请求体中的规则配置
+func readRule(r *http.Request) (config.ForwardRule, error) {
+	data, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return config.ForwardRule{}, err
+	}
+	var rc RuleConfig
+	if err := json.Unmarshal(data, &rc); err != nil {
+		return config.ForwardRule{}, fmt.Errorf("规则格式错误: %v", err)
+	}
+	if err := Validate(&rc.ForwardRule); err != nil {
+		return config.ForwardRule{}, err
+	}
+	return rc.ForwardRule, nil
+}
+
+func respondRule(w http.ResponseWriter, b Backend, name string, code int) {
+	rule, err := find(b, name)
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	writeJSON(w, code, rule)
+}
+
+func statusOf(err error) int {
+	if errors.Is(err, ErrNotFound) {
+		return http.StatusNotFound
+	}
+	if errors.Is(err, ErrUnavailable) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusConflict
+}
+
+type errorBody struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, errorBody{Error: err.Error()})
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package control
+
+import "net"
+
+// listenUnix 创建套接字，这些平台上的访问控制由文件系统的 ACL 决定，不使用文件权限位
+func listenUnix(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,37 @@
+//go:build unix
+
+package control
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// listenUnix 创建只允许当前用户访问的套接字。套接字先在新建的私有目录（0700）中创建并设为 0600，
+// 再移动到 path，其他用户在权限设置好之前无法连接，也不需要修改整个进程的 umask。
+func listenUnix(path string) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".gopf-sock-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, "sock")
+	ln, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(tmp, 0600); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("设置套接字权限失败: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	// 套接字已不在原路径，关闭时由 Server.Close 删除
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	return ln, nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"gopf/config"
+	"gopf/control"
+	"gopf/forwarder"
+	"log/slog"
+	"sync"
+)
+
+// daemon 无界面模式下的规则管理，信号处理和控制接口共用，所有操作串行执行
+type daemon struct {
+	mu         sync.Mutex
+	configFile string
+	cfg        *config.Config
+	env        *forwarder.Env
+	mgr        *forwarder.Manager
+	closed     bool // 已停止所有规则，之后不再重新加载
+}
+
+func (d *daemon) index(name string) int {
+	for i := range d.cfg.Rules {
+		if d.cfg.Rules[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// withRule 按名称查找规则后在锁内执行 fn
+func (d *daemon) withRule(name string, fn func(idx int) error) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	idx := d.index(name)
+	if idx < 0 {
+		return control.ErrNotFound
+	}
+	return fn(idx)
+}
+
+func (d *daemon) stopAll() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.closed = true
+	d.mgr.StopAll()
+}
+
+// reload 重新读取配置文件，只启停有变化的规则，未变化的规则及其连接不受影响。
+// 配置有误时保留当前规则继续运行。
+func (d *daemon) reload() {
+	next, err := config.ReadConfig(d.configFile)
+	if err != nil {
+		slog.Error("重新加载配置失败，继续使用当前配置", "event", "reload_failed", "err", err)
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return
+	}
+
+	diff := d.cfg.Apply(next)
+	if err := d.mgr.Sync(d.cfg.Rules); err != nil {
+		slog.Warn("端口转发启动失败", "event", "rule_failed", "err", err)
+	}
+	// 写回为新规则生成的 ID
+	if diff.NewIDs {
+		if err := d.cfg.SaveIDs(); err != nil {
+			slog.Warn("保存配置失败", "event", "save_failed", "err", err)
+		}
+	}
+	if diff.Empty() {
+		return
+	}
+	slog.Info("已重新加载配置", "event", "reloaded",
+		"added", len(diff.Added), "removed", len(diff.Removed), "changed", len(diff.Changed),
+		"rules", len(d.cfg.Rules), "running", d.mgr.Running())
+}
+
+func (d *daemon) Rules() ([]control.Rule, error) {
+	list := d.mgr.Snapshot()
+	rules := make([]control.Rule, len(list))
+	for i := range list {
+		rules[i] = control.RuleOf(list[i])
+	}
+	return rules, nil
+}
+
+// AddRule 添加规则，start 为 false 时规则保存为停用
+func (d *daemon) AddRule(rule config.ForwardRule, start bool) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	rule.Disabled = !start
+	if err := d.cfg.AddRule(rule); err != nil {
+		return err
+	}
+	added := d.cfg.Rules[len(d.cfg.Rules)-1]
+	if err := d.mgr.Put(added); err != nil {
+		return err
+	}
+	if start {
+		d.mgr.Start(added.ID)
+	}
+	return nil
+}
+
+func (d *daemon) UpdateRule(name string, rule config.ForwardRule) error {
+	return d.withRule(name, func(idx int) error {
+		if err := d.cfg.UpdateRule(idx, rule); err != nil {
+			return err
+		}
+		// 运行中的规则用新配置重启，失败记录在规则的状态中
+		d.mgr.Put(d.cfg.Rules[idx])
+		return nil
+	})
+}
+
+func (d *daemon) DeleteRule(name string) error {
+	return d.withRule(name, func(idx int) error {
+		id := d.cfg.Rules[idx].ID
+		d.mgr.Remove(id)
+		if err := d.cfg.DeleteRule(idx); err != nil {
+			return err
+		}
+		if d.env.Stats != nil {
+			d.env.Stats.Remove(id)
+		}
+		return nil
+	})
+}
+
+// StartRule 启动规则并将其保存为启用
+func (d *daemon) StartRule(name string) error {
+	return d.withRule(name, func(idx int) error {
+		err := d.cfg.SetDisabled(idx, false)
+		d.mgr.Put(d.cfg.Rules[idx])
+		if err := d.mgr.Start(d.cfg.Rules[idx].ID); err != nil {
+			return err
+		}
+		// 规则已启动，保存失败时仍返回错误提示配置未更新
+		return err
+	})
+}
+
+// StopRule 停止规则并将其保存为停用，gopf 重启后也不会运行
+func (d *daemon) StopRule(name string) error {
+	return d.withRule(name, func(idx int) error {
+		// 先停止再更新配置，避免 Put 按新配置重启运行中的规则
+		d.mgr.Stop(d.cfg.Rules[idx].ID)
+		err := d.cfg.SetDisabled(idx, true)
+		d.mgr.Put(d.cfg.Rules[idx])
+		return err
+	})
+}
+
+func (d *daemon) ClearStats(name string, lifetime bool) error {
+	return d.withRule(name, func(idx int) error {
+		rule := &d.cfg.Rules[idx]
+		if lifetime {
+			if d.env.Stats == nil {
+				return fmt.Errorf("未启用累计统计")
+			}
+			d.env.Stats.Reset(rule.ID)
+			return nil
+		}
+		return d.mgr.ClearStats(rule.ID)
+	})
+}
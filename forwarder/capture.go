@@ -0,0 +1,101 @@
+package forwarder
+
+import (
+	"gopf/capture"
+	"net"
+	"sync"
+	"time"
+)
+
+// 抓包默认设置
+const (
+	defaultCaptureDir         = "captures"
+	defaultCaptureMaxSize     = 100 << 20
+	defaultCaptureMaxDuration = 10 * time.Minute
+)
+
+// StartCapture 开始将转发的负载写入新的 pcapng 文件，返回文件路径
+func (f *Forwarder) StartCapture() (string, error) {
+	dir, maxSize, maxDuration := defaultCaptureDir, int64(defaultCaptureMaxSize), defaultCaptureMaxDuration
+	if c := f.rule.Capture; c != nil {
+		if c.Dir != "" {
+			dir = c.Dir
+		}
+		if c.MaxSize > 0 {
+			maxSize = c.MaxSize
+		}
+		if c.MaxDuration > 0 {
+			maxDuration = c.MaxDuration
+		}
+	}
+
+	w, err := capture.Create(dir, f.rule.Name, maxSize, maxDuration)
+	if err != nil {
+		return "", err
+	}
+
+	if old := f.pcap.Swap(w); old != nil {
+		old.Close()
+	}
+	return w.Path(), nil
+}
+
+// StopCapture 停止抓包
+func (f *Forwarder) StopCapture() {
+	if w := f.pcap.Swap(nil); w != nil {
+		w.Close()
+	}
+}
+
+// CaptureFile 返回正在写入的抓包文件路径，未抓包时返回空字符串
+func (f *Forwarder) CaptureFile() string {
+	if w := f.pcap.Load(); w != nil {
+		return w.Path()
+	}
+	return ""
+}
+
+// captureResult 处理写入结果，达到上限时自动停止抓包
+func (f *Forwarder) captureResult(w *capture.Writer, err error) {
+	if err == capture.ErrLimit && f.pcap.CompareAndSwap(w, nil) {
+		w.Close()
+	}
+}
+
+// captureConn 将客户端一侧的双向负载写入合成的 TCP 流
+type captureConn struct {
+	net.Conn
+	f    *Forwarder
+	flow *capture.Flow
+	once sync.Once
+}
+
+func (c *captureConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		w := c.f.pcap.Load()
+		c.f.captureResult(w, c.flow.Data(w, true, p[:n]))
+	}
+	return n, err
+}
+
+func (c *captureConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		w := c.f.pcap.Load()
+		c.f.captureResult(w, c.flow.Data(w, false, p[:n]))
+	}
+	return n, err
+}
+
+func (c *captureConn) Close() error {
+	c.once.Do(func() {
+		w := c.f.pcap.Load()
+		c.f.captureResult(w, c.flow.Close(w))
+	})
+	return c.Conn.Close()
+}
+
+func (c *captureConn) NetConn() net.Conn {
+	return c.Conn
+}
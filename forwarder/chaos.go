@@ -0,0 +1,96 @@
+package forwarder
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"gopf/config"
+)
+
+// SetChaos 开启或关闭规则配置的故障注入，对已建立的连接立即生效
+func (f *Forwarder) SetChaos(on bool) error {
+	if !on {
+		f.chaos.Store(nil)
+		return nil
+	}
+	if f.rule.Chaos == nil {
+		return fmt.Errorf("规则 '%s' 未配置故障注入", f.rule.Name)
+	}
+	c := *f.rule.Chaos
+	f.chaos.Store(&c)
+	return nil
+}
+
+// ChaosEnabled 返回故障注入是否开启
+func (f *Forwarder) ChaosEnabled() bool {
+	return f.chaos.Load() != nil
+}
+
+// rateLimiter 单个方向的带宽限制
+type rateLimiter struct {
+	next time.Time
+}
+
+func (l *rateLimiter) wait(n int, rate int64) {
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / rate))
+	time.Sleep(l.next.Sub(now))
+}
+
+// injectFaults 在转发一个数据块前注入故障，返回 false 表示连接已被中断
+func (f *Forwarder) injectFaults(c *config.ChaosConfig, s *session, limiter *rateLimiter, p []byte) ([]byte, bool) {
+	if c.ResetRate > 0 && rand.Float64() < c.ResetRate {
+		atomic.AddUint64(&f.faultResets, 1)
+		resetConn(s.client)
+		resetConn(s.upstream)
+		return nil, false
+	}
+
+	if c.Latency > 0 || c.Jitter > 0 {
+		delay := c.Latency
+		if c.Jitter > 0 {
+			delay += rand.N(c.Jitter)
+		}
+		atomic.AddUint64(&f.faultDelays, 1)
+		time.Sleep(delay)
+	}
+
+	if c.Bandwidth > 0 {
+		limiter.wait(len(p), c.Bandwidth)
+	}
+
+	if c.CorruptRate > 0 && rand.Float64() < c.CorruptRate {
+		atomic.AddUint64(&f.faultCorruptions, 1)
+		p[rand.IntN(len(p))] ^= byte(1 + rand.IntN(255))
+	}
+
+	if c.TruncateRate > 0 && rand.Float64() < c.TruncateRate {
+		atomic.AddUint64(&f.faultTruncations, 1)
+		return p[:rand.IntN(len(p))], false
+	}
+
+	return p, true
+}
+
+// resetConn 尽可能以 RST 而不是 FIN 关闭连接
+func resetConn(c net.Conn) {
+	for {
+		switch v := c.(type) {
+		case *net.TCPConn:
+			v.SetLinger(0)
+			v.Close()
+			return
+		case interface{ NetConn() net.Conn }:
+			c = v.NetConn()
+		default:
+			c.Close()
+			return
+		}
+	}
+}
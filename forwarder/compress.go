@@ -0,0 +1,69 @@
+package forwarder
+
+import (
+	"compress/flate"
+	"io"
+	"net"
+	"sync/atomic"
+)
+
+// countingConn 统计链路上实际传输的字节数
+type countingConn struct {
+	net.Conn
+	read    *uint64
+	written *uint64
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	atomic.AddUint64(c.read, uint64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	atomic.AddUint64(c.written, uint64(n))
+	return n, err
+}
+
+func (c *countingConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// compressConn 使用 DEFLATE 压缩两端均为 gopf 的链路，
+// 每次写入后立即 Flush，保证交互式协议不会被缓冲
+type compressConn struct {
+	net.Conn
+	zr io.ReadCloser
+	zw *flate.Writer
+}
+
+func newCompressConn(conn net.Conn) *compressConn {
+	zw, _ := flate.NewWriter(conn, flate.DefaultCompression)
+	return &compressConn{
+		Conn: conn,
+		zr:   flate.NewReader(conn),
+		zw:   zw,
+	}
+}
+
+func (c *compressConn) Read(p []byte) (int, error) {
+	return c.zr.Read(p)
+}
+
+func (c *compressConn) Write(p []byte) (int, error) {
+	n, err := c.zw.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, c.zw.Flush()
+}
+
+func (c *compressConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// compressLink 包装压缩链路，read/written 为读取和写入时计入的线路字节计数器
+func compressLink(conn net.Conn, read, written *uint64) net.Conn {
+	return newCompressConn(&countingConn{Conn: conn, read: read, written: written})
+}
@@ -0,0 +1,33 @@
+package forwarder
+
+import (
+	"gopf/audit"
+	"gopf/config"
+	"gopf/hooks"
+	"gopf/logging"
+	"gopf/stats"
+)
+
+// Env 创建转发器时注入的公共依赖，各字段均可为空
+type Env struct {
+	Logs  *logging.Logger
+	Stats *stats.Store
+	Audit *audit.Writer
+	Hooks *hooks.Dispatcher
+}
+
+// New 创建使用这些依赖的转发器
+func (e *Env) New(rule *config.ForwardRule) *Forwarder {
+	f := NewForwarder(rule)
+	if e == nil {
+		return f
+	}
+	if e.Logs != nil {
+		f.SetLogger(e.Logs.Rule(rule))
+	}
+	f.SeedQuota(e.Stats)
+	f.audit = e.Audit
+	// 在恢复配额用量之后设置，重启时已用尽的配额不会再次通知
+	f.hooks = e.Hooks
+	return f
+}
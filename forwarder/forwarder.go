@@ -1,48 +1,175 @@
 package forwarder
 
 import (
+	"errors"
 	"fmt"
+	"gopf/audit"
+	"gopf/capture"
 	"gopf/config"
+	"gopf/hooks"
+	"gopf/logging"
+	"gopf/record"
+	"io"
+	"log/slog"
 	"net"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
 type Forwarder struct {
-	rule         *config.ForwardRule
-	listener     net.Listener
-	done         chan struct{}
-	mu           sync.Mutex
-	active       sync.WaitGroup
-	bytesSent    uint64
-	bytesRecv    uint64
-	connections  uint64
-	forwardCount uint64
-	lastActive   int64
+	rule             *config.ForwardRule
+	published        atomic.Pointer[Stats]
+	log              *slog.Logger
+	audit            *audit.Writer
+	hooks            *hooks.Dispatcher
+	listener         net.Listener
+	relay            *relay
+	ctrl             net.Conn
+	done             chan struct{}
+	mu               sync.Mutex
+	sessMu           sync.Mutex
+	sessions         map[*session]struct{} // 活动的连接，停止时关闭
+	bytesSent        uint64
+	bytesRecv        uint64
+	wireSent         uint64
+	wireRecv         uint64
+	mirrorDrops      uint64
+	mirrorErrors     uint64
+	pcap             atomic.Pointer[capture.Writer]
+	tap              atomic.Pointer[Tap]
+	chaos            atomic.Pointer[config.ChaosConfig]
+	faultDelays      uint64
+	faultResets      uint64
+	faultCorruptions uint64
+	faultTruncations uint64
+	sessionID        uint64
+	connections      uint64
+	totalConns       uint64
+	dialFailures     uint64
+	upstreamDown     atomic.Bool
+	chunksSent       uint64
+	chunksRecv       uint64
+	rates            rateHistory
+	quota            quotaUsage
+	dialTime         histogram
+	tlsTime          histogram
+	ttfb             histogram
+	lastActive       int64
 }
 
 func NewForwarder(rule *config.ForwardRule) *Forwarder {
-	return &Forwarder{
-		rule: rule,
-		done: make(chan struct{}),
+	f := &Forwarder{
+		rule:     rule,
+		log:      logging.Discard(),
+		done:     make(chan struct{}),
+		sessions: make(map[*session]struct{}),
 	}
+	return f
+}
+
+// SetLogger 设置规则使用的日志，需在 Start 之前调用，未设置时不输出日志
+func (f *Forwarder) SetLogger(l *slog.Logger) {
+	f.log = l
 }
 
 func (f *Forwarder) Start() error {
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", f.rule.LocalPort))
+	if err := f.start(); err != nil {
+		f.log.Error("规则启动失败", "event", "rule_failed", "err", err)
+		f.emit(hooks.RuleFailed, "规则启动失败", "err", err.Error())
+		return err
+	}
+	f.log.Info("规则已启动", "event", "rule_started", "type", f.rule.Kind(), "local_port", f.rule.LocalPort)
+	f.emit(hooks.RuleStarted, "规则已启动", "type", f.rule.Kind(), "local_port", strconv.Itoa(f.rule.LocalPort))
+	return nil
+}
+
+// emit 触发钩子事件，kv 为成对的键和值；没有钩子订阅时不做任何事
+func (f *Forwarder) emit(event, message string, kv ...string) {
+	if !f.hooks.Wants(event, f.rule.Name) {
+		return
+	}
+	data := make(map[string]string, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		data[kv[i]] = kv[i+1]
+	}
+	f.hooks.Emit(hooks.Event{Event: event, Rule: f.rule.Name, Message: message, Data: data})
+}
+
+func (f *Forwarder) start() error {
+	switch f.rule.Compress {
+	case "", config.CompressLocal, config.CompressRemote:
+	default:
+		return fmt.Errorf("未知的压缩方式: %s", f.rule.Compress)
+	}
+	if err := validateQuota(f.rule.Quota); err != nil {
+		return err
+	}
+
+	var err error
+	switch f.rule.Kind() {
+	case config.RuleForward:
+		err = f.listen(f.handleConnection)
+	case config.RuleRelay:
+		if err := f.rule.CheckRelay(); err != nil {
+			return err
+		}
+		f.relay = newRelay(f)
+		err = f.listen(f.relay.handleControl)
+	case config.RuleReverse:
+		err = f.startReverse()
+	default:
+		err = fmt.Errorf("未知的规则类型: %s", f.rule.Type)
+	}
 	if err != nil {
 		return err
 	}
 
-	f.listener = listener
 	atomic.StoreUint64(&f.bytesSent, 0)
 	atomic.StoreUint64(&f.bytesRecv, 0)
+	atomic.StoreUint64(&f.wireSent, 0)
+	atomic.StoreUint64(&f.wireRecv, 0)
+	atomic.StoreUint64(&f.mirrorDrops, 0)
+	atomic.StoreUint64(&f.mirrorErrors, 0)
+	atomic.StoreUint64(&f.faultDelays, 0)
+	atomic.StoreUint64(&f.faultResets, 0)
+	atomic.StoreUint64(&f.faultCorruptions, 0)
+	atomic.StoreUint64(&f.faultTruncations, 0)
 	atomic.StoreUint64(&f.connections, 0)
-	atomic.StoreUint64(&f.forwardCount, 0)
+	atomic.StoreUint64(&f.totalConns, 0)
+	atomic.StoreUint64(&f.dialFailures, 0)
+	atomic.StoreUint64(&f.chunksSent, 0)
+	atomic.StoreUint64(&f.chunksRecv, 0)
+	f.upstreamDown.Store(false)
+	f.rates.reset()
+	f.resetLatency()
+	f.rollQuota(time.Now())
 	f.updateLastActive()
-	go f.accept()
+	f.publish(0, 0)
 	go f.updateStats()
+
+	if f.rule.Capture != nil && f.rule.Capture.Enabled {
+		// 抓包失败不影响转发，规则继续运行
+		if _, err := f.StartCapture(); err != nil {
+			f.log.Warn("开始抓包失败", "event", "capture_failed", "dir", f.rule.Capture.Dir, "err", err)
+		}
+	}
+	if f.rule.Chaos != nil && f.rule.Chaos.Enabled {
+		f.SetChaos(true)
+	}
+	return nil
+}
+
+// listen 监听本地端口，并将接受的连接交给 handler 处理
+func (f *Forwarder) listen(handler func(net.Conn)) error {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", f.rule.LocalPort))
+	if err != nil {
+		return err
+	}
+
+	f.listener = listener
+	go f.accept(listener, handler)
 	return nil
 }
 
@@ -54,29 +181,44 @@ func (f *Forwarder) updateStats() {
 		select {
 		case <-f.done:
 			return
-		case <-ticker.C:
-			atomic.StoreUint64(&f.rule.BytesSent, atomic.LoadUint64(&f.bytesSent))
-			atomic.StoreUint64(&f.rule.BytesRecv, atomic.LoadUint64(&f.bytesRecv))
-			atomic.StoreUint64(&f.rule.Connections, atomic.LoadUint64(&f.connections))
-			atomic.StoreUint64(&f.rule.ForwardCount, atomic.LoadUint64(&f.forwardCount))
-			atomic.StoreInt64(&f.rule.LastActive, atomic.LoadInt64(&f.lastActive))
+		case now := <-ticker.C:
+			rateSent, rateRecv := f.sampleRates()
+			f.rollQuota(now)
+			f.publish(rateSent, rateRecv)
 		}
 	}
 }
 
 func (f *Forwarder) Stop() {
 	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.listener == nil && f.ctrl == nil {
+		return
+	}
+
+	close(f.done)
 	if f.listener != nil {
-		close(f.done)
 		f.listener.Close()
 		f.listener = nil
 	}
-	f.mu.Unlock()
+	if f.relay != nil {
+		f.relay.close()
+	}
+	if f.ctrl != nil {
+		f.ctrl.Close()
+		f.ctrl = nil
+	}
+	f.closeSessions()
+	f.StopCapture()
+	f.publish(0, 0)
+	f.log.Info("规则已停止", "event", "rule_stopped")
+	f.emit(hooks.RuleStopped, "规则已停止")
 }
 
-func (f *Forwarder) accept() {
+func (f *Forwarder) accept(listener net.Listener, handler func(net.Conn)) {
 	for {
-		conn, err := f.listener.Accept()
+		conn, err := listener.Accept()
 		if err != nil {
 			select {
 			case <-f.done:
@@ -86,66 +228,281 @@ func (f *Forwarder) accept() {
 			}
 		}
 
-		atomic.AddUint64(&f.connections, 1)
-		go f.handleConnection(conn)
+		go handler(conn)
 	}
 }
 
 func (f *Forwarder) handleConnection(local net.Conn) {
-	defer atomic.AddUint64(&f.connections, ^uint64(0))
+	f.log.Debug("接受连接", "event", "accept", "client", local.RemoteAddr().String())
+	if !f.admitConn() {
+		f.log.Warn("超出配额，拒绝连接", "event", "quota_rejected", "client", local.RemoteAddr().String())
+		local.Close()
+		return
+	}
 
-	remote, err := net.Dial("tcp", fmt.Sprintf("%s:%d", f.rule.RemoteHost, f.rule.RemotePort))
+	start := time.Now()
+	upstream := net.JoinHostPort(f.rule.RemoteHost, strconv.Itoa(f.rule.RemotePort))
+	remote, err := net.Dial("tcp", upstream)
+	f.dialResult(upstream, err)
 	if err != nil {
+		f.log.Warn("连接上游失败", "event", "dial_failed", "client", local.RemoteAddr().String(), "upstream", upstream, "err", err)
 		local.Close()
 		return
 	}
+	f.dialTime.observe(time.Since(start))
+
+	switch f.rule.Compress {
+	case config.CompressLocal:
+		local = compressLink(local, &f.wireSent, &f.wireRecv)
+	case config.CompressRemote:
+		remote = compressLink(remote, &f.wireRecv, &f.wireSent)
+	}
+
+	s := f.newSession(local, remote)
+	go f.pipe(s, true)
+	go f.pipe(s, false)
+}
+
+// dialResult 记录一次上游拨号的结果，失败时将上游标记为不可用，状态变化时触发钩子
+func (f *Forwarder) dialResult(upstream string, err error) {
+	if err != nil {
+		atomic.AddUint64(&f.dialFailures, 1)
+		if !f.upstreamDown.Swap(true) {
+			f.emit(hooks.UpstreamDown, "上游不可用", "upstream", upstream, "err", err.Error())
+		}
+		return
+	}
+	if f.upstreamDown.Swap(false) {
+		f.emit(hooks.UpstreamUp, "上游已恢复", "upstream", upstream)
+	}
+}
 
-	go f.pipe(local, remote)
-	go f.pipe(remote, local)
+// session 一个被转发的连接
+type session struct {
+	id       uint64
+	client   net.Conn
+	upstream net.Conn
+	pipes    int32 // 仍在运行的单向转发数，归零时连接结束
+	start    time.Time
+	firstUp  int64     // 客户端首次发往上游的时间（UnixNano）
+	tls      int       // TLS 握手测量状态
+	tlsStart time.Time // 客户端发出 ClientHello 的时间
+	gotFirst bool      // 已收到上游的首个数据块
+	sent     uint64    // 本连接发往上游的字节数
+	recv     uint64    // 本连接发往客户端的字节数
+	once     sync.Once
+	reason   string // 关闭原因，以最先结束的方向为准
 }
 
-func (f *Forwarder) pipe(src, dst net.Conn) {
+func (s *session) closeWith(reason string) {
+	s.once.Do(func() { s.reason = reason })
+}
+
+// closeSessions 关闭所有活动的连接，阻塞在读取上的 pipe 随之结束
+func (f *Forwarder) closeSessions() {
+	f.sessMu.Lock()
+	defer f.sessMu.Unlock()
+
+	for s := range f.sessions {
+		s.closeWith(audit.ReasonStopped)
+		s.client.Close()
+		s.upstream.Close()
+	}
+}
+
+// endSession 在两个方向都结束后记录连接关闭
+func (f *Forwarder) endSession(s *session) {
+	f.sessMu.Lock()
+	delete(f.sessions, s)
+	f.sessMu.Unlock()
+	atomic.AddUint64(&f.connections, ^uint64(0))
+
+	end := time.Now()
+	rec := audit.Record{
+		Start:     s.start,
+		End:       end,
+		Rule:      f.rule.Name,
+		Client:    s.client.RemoteAddr().String(),
+		Upstream:  s.upstream.RemoteAddr().String(),
+		Duration:  end.Sub(s.start).Milliseconds(),
+		BytesSent: atomic.LoadUint64(&s.sent),
+		BytesRecv: atomic.LoadUint64(&s.recv),
+		Reason:    s.reason,
+	}
+	f.log.Info("连接关闭", "event", "close", "conn", s.id,
+		"client", rec.Client, "upstream", rec.Upstream,
+		"sent", rec.BytesSent, "recv", rec.BytesRecv,
+		"duration_ms", rec.Duration, "reason", rec.Reason)
+
+	if f.audit != nil {
+		if err := f.audit.Write(rec); err != nil {
+			f.log.Error("写入审计日志失败", "event", "audit_failed", "err", err)
+		}
+	}
+	f.emit(hooks.ConnClose, "连接关闭", "conn", strconv.FormatUint(s.id, 10),
+		"client", rec.Client, "upstream", rec.Upstream,
+		"sent", strconv.FormatUint(rec.BytesSent, 10), "recv", strconv.FormatUint(rec.BytesRecv, 10),
+		"duration_ms", strconv.FormatInt(rec.Duration, 10), "reason", rec.Reason)
+}
+
+// newSession 为连接分配编号，并为客户端一侧挂载流量镜像、会话录制和抓包。
+// 调用方需随后启动双向的 pipe，连接在两个方向都结束后才不再计入活动连接。
+func (f *Forwarder) newSession(client, upstream net.Conn) *session {
+	s := &session{
+		id:       atomic.AddUint64(&f.sessionID, 1),
+		upstream: upstream,
+		pipes:    2,
+		start:    time.Now(),
+	}
+	atomic.AddUint64(&f.connections, 1)
+	atomic.AddUint64(&f.totalConns, 1)
+
+	if f.rule.Mirror != "" {
+		client = &mirrorConn{Conn: client, m: f.newMirror()}
+	}
+
+	if f.rule.Record != "" {
+		w, err := record.Create(f.rule.Record, record.Meta{
+			Rule:     f.rule.Name,
+			Client:   client.RemoteAddr().String(),
+			Upstream: upstream.RemoteAddr().String(),
+			Start:    time.Now(),
+		})
+		if err != nil {
+			f.log.Warn("创建录制文件失败，本次连接不录制", "event", "record_failed", "rule", f.rule.Name, "dir", f.rule.Record, "err", err)
+		} else {
+			client = &recordConn{Conn: client, w: w}
+		}
+	}
+
+	// 始终跟踪合成 TCP 流的序列号，以便运行中随时开始抓包
+	s.client = &captureConn{Conn: client, f: f, flow: capture.NewFlow(client.RemoteAddr(), upstream.RemoteAddr())}
+
+	f.sessMu.Lock()
+	f.sessions[s] = struct{}{}
+	f.sessMu.Unlock()
+	// 已停止时 closeSessions 不会再关闭这个连接，这里直接关闭
+	select {
+	case <-f.done:
+		s.closeWith(audit.ReasonStopped)
+		client.Close()
+		upstream.Close()
+	default:
+	}
+
+	f.emit(hooks.ConnOpen, "新连接", "conn", strconv.FormatUint(s.id, 10),
+		"client", client.RemoteAddr().String(), "upstream", upstream.RemoteAddr().String())
+	return s
+}
+
+// pipe 单向转发数据，up 为 true 表示客户端发往上游（计入发送），否则为上游发往客户端（计入接收）
+func (f *Forwarder) pipe(s *session, up bool) {
+	src, dst := s.upstream, s.client
+	bytes, chunks, own := &f.bytesRecv, &f.chunksRecv, &s.recv
+	if up {
+		src, dst = s.client, s.upstream
+		bytes, chunks, own = &f.bytesSent, &f.chunksSent, &s.sent
+	}
+
+	// 读写失败时区分是哪一端出的问题
+	srcClosed, srcErr, dstErr := audit.ReasonUpstreamClosed, audit.ReasonUpstreamError, audit.ReasonClientError
+	if up {
+		srcClosed, srcErr, dstErr = audit.ReasonClientClosed, audit.ReasonClientError, audit.ReasonUpstreamError
+	}
+
+	defer func() {
+		if atomic.AddInt32(&s.pipes, -1) == 0 {
+			f.endSession(s)
+		}
+	}()
 	defer src.Close()
 	defer dst.Close()
 
+	var limiter, throttle rateLimiter
 	buf := make([]byte, 32*1024)
 	for {
 		select {
 		case <-f.done:
+			s.closeWith(audit.ReasonStopped)
 			return
 		default:
 			n, err := src.Read(buf)
+			// 压缩链路没有结束块，对端关闭时读到的是 ErrUnexpectedEOF
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				s.closeWith(srcClosed)
+				return
+			}
 			if err != nil {
+				s.closeWith(srcErr)
 				return
 			}
 
 			if n > 0 {
-				if _, err := dst.Write(buf[:n]); err != nil {
-					return
+				// 在故障注入和限速之前计时，测量的是真实链路的延迟
+				if up {
+					f.observeUp(s, buf[:n], time.Now())
+				} else {
+					f.observeDown(s, time.Now())
+				}
+
+				data, ok := buf[:n], true
+				if c := f.chaos.Load(); c != nil {
+					data, ok = f.injectFaults(c, s, &limiter, data)
 				}
+				if len(data) > 0 {
+					if !f.enforceQuota(&throttle, len(data)) {
+						s.closeWith(audit.ReasonQuota)
+						return
+					}
+					if _, err := dst.Write(data); err != nil {
+						s.closeWith(dstErr)
+						return
+					}
+					f.quotaBytes(len(data))
 
-				f.updateLastActive()
-				atomic.AddUint64(&f.forwardCount, 1)
+					f.updateLastActive()
+					atomic.AddUint64(chunks, 1)
+					atomic.AddUint64(bytes, uint64(len(data)))
+					atomic.AddUint64(own, uint64(len(data)))
 
-				if dst.RemoteAddr().String() == fmt.Sprintf("%s:%d", f.rule.RemoteHost, f.rule.RemotePort) {
-					atomic.AddUint64(&f.bytesSent, uint64(n))
-				} else {
-					atomic.AddUint64(&f.bytesRecv, uint64(n))
+					// 仅在有观察者时复制数据
+					if t := f.tap.Load(); t != nil {
+						t.emit(s, up, data)
+					}
+				}
+
+				// 故障注入中断了连接
+				if !ok {
+					s.closeWith(audit.ReasonFault)
+					return
 				}
 			}
 		}
 	}
 }
 
-func (f *Forwarder) ClearStats() {
-	atomic.StoreUint64(&f.bytesSent, 0)
-	atomic.StoreUint64(&f.bytesRecv, 0)
-	atomic.StoreUint64(&f.connections, 0)
-	atomic.StoreUint64(&f.forwardCount, 0)
-	atomic.StoreUint64(&f.rule.BytesSent, 0)
-	atomic.StoreUint64(&f.rule.BytesRecv, 0)
-	atomic.StoreUint64(&f.rule.Connections, 0)
-	atomic.StoreUint64(&f.rule.ForwardCount, 0)
+// ClearStats 清空统计，返回清空前的流量和连接计数，每项计数的读取与清零是原子的
+func (f *Forwarder) ClearStats() Stats {
+	cleared := Stats{
+		BytesSent:  atomic.SwapUint64(&f.bytesSent, 0),
+		BytesRecv:  atomic.SwapUint64(&f.bytesRecv, 0),
+		TotalConns: atomic.SwapUint64(&f.totalConns, 0),
+	}
+	atomic.StoreUint64(&f.wireSent, 0)
+	atomic.StoreUint64(&f.wireRecv, 0)
+	atomic.StoreUint64(&f.mirrorDrops, 0)
+	atomic.StoreUint64(&f.mirrorErrors, 0)
+	atomic.StoreUint64(&f.faultDelays, 0)
+	atomic.StoreUint64(&f.faultResets, 0)
+	atomic.StoreUint64(&f.faultCorruptions, 0)
+	atomic.StoreUint64(&f.faultTruncations, 0)
+	atomic.StoreUint64(&f.dialFailures, 0)
+	atomic.StoreUint64(&f.chunksSent, 0)
+	atomic.StoreUint64(&f.chunksRecv, 0)
+	f.resetLatency()
+	last := f.Stats()
+	f.publish(last.RateSent, last.RateRecv)
+	return cleared
 }
 
 func (f *Forwarder) GetLocalPort() int {
@@ -0,0 +1,156 @@
+package forwarder
+
+import (
+	"bytes"
+	"gopf/config"
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// freePort 返回一个当前空闲的本地端口
+func freePort(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// startUpstream 启动上游服务：每读到 reqSize 字节回复 respSize 字节，回复与请求大小不同，便于区分方向
+func startUpstream(t *testing.T, reqSize, respSize int) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req := make([]byte, reqSize)
+				resp := bytes.Repeat([]byte{'r'}, respSize)
+				for {
+					if _, err := io.ReadFull(conn, req); err != nil {
+						return
+					}
+					if _, err := conn.Write(resp); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// 上游以主机名给出时，发往上游和返回客户端的数据也要分别计入 BytesSent 和 BytesRecv
+func TestForwardCountsByDirection(t *testing.T) {
+	const (
+		rounds   = 5
+		reqSize  = 100
+		respSize = 300
+	)
+
+	rule := &config.ForwardRule{
+		Name:       "test",
+		LocalPort:  freePort(t),
+		RemoteHost: "localhost",
+		RemotePort: startUpstream(t, reqSize, respSize),
+	}
+	f := (*Env)(nil).New(rule)
+	if err := f.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Stop()
+
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(rule.LocalPort)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 每轮等收到完整回复后再发下一轮，使每次写入恰好对应一个数据块
+	req := bytes.Repeat([]byte{'q'}, reqSize)
+	resp := make([]byte, respSize)
+	for i := 0; i < rounds; i++ {
+		if _, err := conn.Write(req); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadFull(conn, resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn.Close()
+
+	// 统计每秒同步一次，等连接结束后的快照
+	var s Stats
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s = f.Stats()
+		if s.TotalConns == 1 && s.Connections == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("连接未结束: %+v", s)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if s.BytesSent != rounds*reqSize || s.BytesRecv != rounds*respSize {
+		t.Errorf("字节数 sent=%d recv=%d，期望 sent=%d recv=%d", s.BytesSent, s.BytesRecv, rounds*reqSize, rounds*respSize)
+	}
+	if s.ChunksSent != rounds || s.ChunksRecv != rounds {
+		t.Errorf("数据块 sent=%d recv=%d，期望均为 %d", s.ChunksSent, s.ChunksRecv, rounds)
+	}
+}
+
+// 停止规则时关闭仍在转发的连接，客户端随即读到连接关闭
+func TestStopClosesSessions(t *testing.T) {
+	rule := &config.ForwardRule{
+		Name:       "test",
+		LocalPort:  freePort(t),
+		RemoteHost: "127.0.0.1",
+		RemotePort: startUpstream(t, 1, 1),
+	}
+	f := (*Env)(nil).New(rule)
+	if err := f.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(rule.LocalPort)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// 收到回复说明连接已建立并在转发
+	if _, err := conn.Write([]byte{'q'}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(conn, make([]byte, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	f.Stop()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("停止后读取返回 %v，期望 EOF", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadUint64(&f.connections) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("停止后仍有活动连接")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,165 @@
+package forwarder
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets 延迟直方图各桶的上界，超过最后一个上界的计入溢出桶
+var latencyBuckets = [...]time.Duration{
+	time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	20 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2 * time.Second,
+	5 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+}
+
+// histogram 无锁的延迟直方图
+type histogram struct {
+	counts [len(latencyBuckets) + 1]uint64
+	sum    int64
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := 0
+	for i < len(latencyBuckets) && d > latencyBuckets[i] {
+		i++
+	}
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddInt64(&h.sum, int64(d))
+}
+
+func (h *histogram) reset() {
+	for i := range h.counts {
+		atomic.StoreUint64(&h.counts[i], 0)
+	}
+	atomic.StoreInt64(&h.sum, 0)
+}
+
+// snapshot 计算分位数，在所在桶内按线性插值估算
+func (h *histogram) snapshot() Latency {
+	var counts [len(latencyBuckets) + 1]uint64
+	var total uint64
+	for i := range counts {
+		counts[i] = atomic.LoadUint64(&h.counts[i])
+		total += counts[i]
+	}
+
+	l := Latency{
+		Sum:   time.Duration(atomic.LoadInt64(&h.sum)),
+		Count: total,
+	}
+	if total == 0 {
+		return l
+	}
+
+	quantile := func(q float64) time.Duration {
+		rank := q * float64(total)
+		var seen uint64
+		for i, c := range counts {
+			if c == 0 || float64(seen+c) < rank {
+				seen += c
+				continue
+			}
+			if i == len(latencyBuckets) {
+				return latencyBuckets[i-1]
+			}
+			lower := time.Duration(0)
+			if i > 0 {
+				lower = latencyBuckets[i-1]
+			}
+			frac := (rank - float64(seen)) / float64(c)
+			return lower + time.Duration(frac*float64(latencyBuckets[i]-lower))
+		}
+		return latencyBuckets[len(latencyBuckets)-1]
+	}
+
+	l.P50, l.P95, l.P99 = quantile(0.50), quantile(0.95), quantile(0.99)
+	return l
+}
+
+// 连接首个数据块的 TLS 记录类型
+const (
+	tlsHandshake   = 0x16
+	tlsApplication = 0x17
+)
+
+// isClientHello 判断客户端的首个数据块是否为 TLS 握手
+func isClientHello(p []byte) bool {
+	return len(p) >= 3 && p[0] == tlsHandshake && p[1] == 0x03
+}
+
+// hasAppData 判断数据块中是否出现了加密的应用数据记录。
+// TLS 1.2 中这是握手后的第一条应用数据，TLS 1.3 中客户端的 Finished 也以该类型发送，
+// 两者都标志着握手已完成。只解析从数据块开头起完整的记录头。
+func hasAppData(p []byte) bool {
+	for len(p) >= 5 {
+		if p[0] == tlsApplication {
+			return true
+		}
+		n := 5 + (int(p[3])<<8 | int(p[4]))
+		if n > len(p) {
+			return false
+		}
+		p = p[n:]
+	}
+	return false
+}
+
+// observeUp 在客户端数据首次发往上游时记录时间，并被动测量 TLS 握手耗时
+func (f *Forwarder) observeUp(s *session, p []byte, now time.Time) {
+	atomic.CompareAndSwapInt64(&s.firstUp, 0, now.UnixNano())
+
+	switch s.tls {
+	case tlsUnknown:
+		if isClientHello(p) {
+			s.tlsStart = now
+			s.tls = tlsPending
+		} else {
+			s.tls = tlsNone
+		}
+	case tlsPending:
+		if hasAppData(p) {
+			f.tlsTime.observe(now.Sub(s.tlsStart))
+			s.tls = tlsDone
+		}
+	}
+}
+
+// observeDown 在收到上游的首个数据块时记录首字节时间。
+// 起点为客户端首次发出数据的时刻，服务端先发言的协议则从连接建立时算起。
+func (f *Forwarder) observeDown(s *session, now time.Time) {
+	if s.gotFirst {
+		return
+	}
+	s.gotFirst = true
+
+	start := s.start
+	if t := atomic.LoadInt64(&s.firstUp); t != 0 {
+		start = time.Unix(0, t)
+	}
+	f.ttfb.observe(now.Sub(start))
+}
+
+// TLS 握手测量状态，只在客户端发往上游的方向读写
+const (
+	tlsUnknown = iota
+	tlsNone
+	tlsPending
+	tlsDone
+)
+
+func (f *Forwarder) resetLatency() {
+	f.dialTime.reset()
+	f.tlsTime.reset()
+	f.ttfb.reset()
+}
@@ -0,0 +1,434 @@
+package forwarder
+
+import (
+	"errors"
+	"fmt"
+	"gopf/config"
+	"gopf/stats"
+	"sync"
+)
+
+// ErrNoRule 指定 ID 的规则不存在
+var ErrNoRule = errors.New("规则不存在")
+
+// 规则变化事件的类型
+const (
+	EventAdded   = "added"
+	EventUpdated = "updated"
+	EventRemoved = "removed"
+	EventStarted = "started"
+	EventStopped = "stopped"
+	EventFailed  = "failed"
+)
+
+// Event 规则的变化，由 Manager.Subscribe 推送
+type Event struct {
+	Type string
+	ID   string
+	Name string
+	Err  string // EventFailed 时为启动失败的原因
+}
+
+// RuleStatus 规则的配置及其运行状态
+type RuleStatus struct {
+	config.ForwardRule
+	Stats
+	Running bool
+	Error   string // 最近一次启动失败的原因
+}
+
+// Manager 管理一组规则及其转发器，界面、无界面模式和其他嵌入 gopf 的程序共用。
+// 规则按 ID 保存配置的副本，运行状态和统计由 Manager 持有，调用方之后修改或重新分配
+// 配置切片不会影响运行中的规则。所有方法都可以并发调用。
+//
+// 启动转发器可能耗时较长（reverse 规则连接中继最长等待 10 秒），期间不持有锁，
+// Snapshot 等查询照常返回；修改同一规则的操作等待启动完成后再执行。
+type Manager struct {
+	env   *Env
+	mu    sync.Mutex
+	cond  *sync.Cond // 规则启动完成时通知，与 mu 配合使用
+	rules map[string]*managed
+	order []string // 规则 ID，按加入的顺序，Sync 后与配置一致
+	subs  map[chan Event]struct{}
+}
+
+type managed struct {
+	rule     config.ForwardRule
+	fwd      *Forwarder // 未运行时为空
+	starting bool       // 正在锁外启动转发器
+	last     Stats      // 停止后保留的最后一次统计
+	err      string
+}
+
+// NewManager 创建使用 env 中依赖的 Manager，env 可以为空
+func NewManager(env *Env) *Manager {
+	m := &Manager{
+		env:   env,
+		rules: make(map[string]*managed),
+		subs:  make(map[chan Event]struct{}),
+	}
+	m.cond = sync.NewCond(&m.mu)
+	return m
+}
+
+// wait 等待规则正在进行的启动完成，调用时需持有 m.mu，等待期间会暂时释放。
+// 返回 false 表示等待期间规则已被移除。
+func (m *Manager) wait(e *managed) bool {
+	for e.starting {
+		m.cond.Wait()
+	}
+	return m.rules[e.rule.ID] == e
+}
+
+// Put 加入规则，已有相同 ID 的规则时更新其配置；运行中的规则配置有变化时用新配置重启，
+// 返回重启的错误。新加入的规则不会自动启动。
+func (m *Manager) Put(rule config.ForwardRule) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.put(rule)
+}
+
+func (m *Manager) put(rule config.ForwardRule) error {
+	if rule.ID == "" {
+		return fmt.Errorf("规则 %s 缺少 ID", rule.Name)
+	}
+	e, ok := m.rules[rule.ID]
+	for ok && !m.wait(e) {
+		e, ok = m.rules[rule.ID]
+	}
+	if !ok {
+		m.rules[rule.ID] = &managed{rule: rule}
+		m.order = append(m.order, rule.ID)
+		m.emit(EventAdded, &rule, "")
+		return nil
+	}
+	if e.rule.SameConfig(&rule) {
+		return nil
+	}
+	e.rule = rule
+	m.emit(EventUpdated, &rule, "")
+	if e.fwd == nil {
+		return nil
+	}
+	m.stop(e)
+	return m.start(e)
+}
+
+// Remove 停止并移除规则
+func (m *Manager) Remove(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.remove(id)
+}
+
+func (m *Manager) remove(id string) {
+	e, ok := m.rules[id]
+	if !ok || !m.wait(e) {
+		return
+	}
+	m.stop(e)
+	delete(m.rules, id)
+	for i, v := range m.order {
+		if v == id {
+			m.order = append(m.order[:i], m.order[i+1:]...)
+			break
+		}
+	}
+	m.emit(EventRemoved, &e.rule, "")
+}
+
+// Sync 使规则与 rules 一致：移除不在其中的规则，新规则加入后立即启动，
+// 配置有变化的规则只在运行中时重启，未变化的规则及其连接不受影响。
+// 停用（Disabled）的规则不会启动，运行中的被停用时停止，重新启用时启动。
+// 返回各规则启动失败的错误。
+func (m *Manager) Sync(rules []config.ForwardRule) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keep := make(map[string]bool, len(rules))
+	for i := range rules {
+		keep[rules[i].ID] = true
+	}
+	for _, id := range append([]string(nil), m.order...) {
+		if !keep[id] {
+			m.remove(id)
+		}
+	}
+
+	var errs []error
+	order := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		e, existed := m.rules[rule.ID]
+		enabled := !rule.Disabled && (!existed || e.rule.Disabled)
+		// 停用的规则先停止，不再按新配置重启
+		if existed && rule.Disabled {
+			m.stop(e)
+		}
+		err := m.put(rule)
+		if err == nil && enabled {
+			err = m.start(m.rules[rule.ID])
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", rule.Name, err))
+		}
+		if _, ok := m.rules[rule.ID]; ok {
+			order = append(order, rule.ID)
+		}
+	}
+	// 启动规则时不持有锁，期间通过 Put 加入的规则排在最后
+	listed := make(map[string]bool, len(order))
+	for _, id := range order {
+		listed[id] = true
+	}
+	for _, id := range m.order {
+		if _, ok := m.rules[id]; ok && !listed[id] {
+			order = append(order, id)
+		}
+	}
+	m.order = order
+	return errors.Join(errs...)
+}
+
+// Start 启动规则，已在运行时不做任何事
+func (m *Manager) Start(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.rules[id]
+	if !ok {
+		return ErrNoRule
+	}
+	return m.start(e)
+}
+
+// start 启动规则的转发器，调用时需持有 m.mu。启动期间释放锁，完成后重新加锁再安装转发器。
+func (m *Manager) start(e *managed) error {
+	if !m.wait(e) {
+		return ErrNoRule
+	}
+	if e.fwd != nil {
+		return nil
+	}
+	// 转发器持有自己的配置副本，之后 Put 修改配置时不与其并发读写
+	rule := e.rule
+	e.starting = true
+	m.mu.Unlock()
+	f := m.env.New(&rule)
+	err := f.Start()
+	m.mu.Lock()
+	e.starting = false
+	m.cond.Broadcast()
+
+	if err != nil {
+		e.err = err.Error()
+		m.emit(EventFailed, &e.rule, e.err)
+		return err
+	}
+	// 修改该规则的操作都会等待启动完成，这里仅作防御：规则已被移除或已有转发器时丢弃
+	if m.rules[rule.ID] != e {
+		f.Stop()
+		return ErrNoRule
+	}
+	if e.fwd != nil {
+		f.Stop()
+		return nil
+	}
+	e.fwd, e.err = f, ""
+	m.emit(EventStarted, &e.rule, "")
+	return nil
+}
+
+// Stop 停止规则并清除启动失败的错误，停止前的统计保留到下次启动
+func (m *Manager) Stop(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.rules[id]
+	if !ok {
+		return ErrNoRule
+	}
+	m.stop(e)
+	return nil
+}
+
+func (m *Manager) stop(e *managed) {
+	m.wait(e)
+	e.err = ""
+	if e.fwd == nil {
+		return
+	}
+	e.fwd.Stop()
+	e.last = e.fwd.Stats()
+	e.fwd = nil
+	m.flush(e.rule.ID, e.last)
+	m.emit(EventStopped, &e.rule, "")
+}
+
+// Restart 停止后重新启动规则，未运行的规则直接启动
+func (m *Manager) Restart(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.rules[id]
+	if !ok {
+		return ErrNoRule
+	}
+	m.stop(e)
+	return m.start(e)
+}
+
+// StopAll 停止所有规则，规则仍保留在 Manager 中
+func (m *Manager) StopAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, id := range append([]string(nil), m.order...) {
+		if e, ok := m.rules[id]; ok {
+			m.stop(e)
+		}
+	}
+}
+
+// ClearStats 清空规则本次运行的统计
+func (m *Manager) ClearStats(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.rules[id]
+	if !ok {
+		return ErrNoRule
+	}
+	if e.fwd != nil {
+		m.flush(id, e.fwd.ClearStats())
+	} else {
+		e.last = Stats{}
+	}
+	return nil
+}
+
+// Forwarder 返回规则运行中的转发器，用于抓包、检视等直接操作，未运行时返回 nil
+func (m *Manager) Forwarder(id string) *Forwarder {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e, ok := m.rules[id]; ok {
+		return e.fwd
+	}
+	return nil
+}
+
+// Status 返回规则的配置和运行状态
+func (m *Manager) Status(id string) (RuleStatus, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.rules[id]
+	if !ok {
+		return RuleStatus{}, false
+	}
+	return e.status(), true
+}
+
+// Snapshot 按顺序返回所有规则的配置和运行状态
+func (m *Manager) Snapshot() []RuleStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := make([]RuleStatus, len(m.order))
+	for i, id := range m.order {
+		list[i] = m.rules[id].status()
+	}
+	return list
+}
+
+// Running 返回运行中的规则数
+func (m *Manager) Running() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for _, e := range m.rules {
+		if e.fwd != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// RecordStats 将运行中规则的会话计数计入 env 中的累计统计，未配置累计统计时不做任何事。
+// 与启停规则互斥，停止时已计入的计数不会再次计入。
+func (m *Manager) RecordStats() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	store := m.store()
+	if store == nil {
+		return
+	}
+	var samples []stats.Sample
+	for _, id := range m.order {
+		if e := m.rules[id]; e.fwd != nil {
+			samples = append(samples, sampleOf(id, e.fwd.Stats()))
+		}
+	}
+	store.Update(samples)
+}
+
+// flush 在转发器停止或清空统计前计入最后的会话计数，调用时需持有 m.mu
+func (m *Manager) flush(id string, s Stats) {
+	if store := m.store(); store != nil {
+		store.Flush(sampleOf(id, s))
+	}
+}
+
+func (m *Manager) store() *stats.Store {
+	if m.env == nil {
+		return nil
+	}
+	return m.env.Stats
+}
+
+func sampleOf(id string, s Stats) stats.Sample {
+	return stats.Sample{ID: id, Counters: stats.Counters{
+		BytesSent:   s.BytesSent,
+		BytesRecv:   s.BytesRecv,
+		Connections: s.TotalConns,
+	}}
+}
+
+func (e *managed) status() RuleStatus {
+	s := RuleStatus{ForwardRule: e.rule, Stats: e.last, Running: e.fwd != nil, Error: e.err}
+	if e.fwd != nil {
+		s.Stats = e.fwd.Stats()
+	}
+	return s
+}
+
+// Subscribe 订阅规则的变化，接收不及时的事件会被丢弃。调用返回的函数取消订阅。
+func (m *Manager) Subscribe(buf int) (<-chan Event, func()) {
+	ch := make(chan Event, buf)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.subs[ch] = struct{}{}
+
+	return ch, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if _, ok := m.subs[ch]; ok {
+			delete(m.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// emit 通知订阅者，调用时需持有 m.mu
+func (m *Manager) emit(typ string, rule *config.ForwardRule, err string) {
+	e := Event{Type: typ, ID: rule.ID, Name: rule.Name, Err: err}
+	for ch := range m.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package forwarder
+
+import (
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	mirrorQueueSize    = 64
+	mirrorDialTimeout  = 5 * time.Second
+	mirrorWriteTimeout = 5 * time.Second
+)
+
+// mirror 将客户端发出的数据复制到影子上游并丢弃其响应。
+// 影子上游过慢时数据直接丢弃，不会拖慢主链路。
+type mirror struct {
+	f      *Forwarder
+	ch     chan []byte
+	mu     sync.Mutex
+	closed bool
+}
+
+func (f *Forwarder) newMirror() *mirror {
+	m := &mirror{
+		f:  f,
+		ch: make(chan []byte, mirrorQueueSize),
+	}
+	go m.run()
+	return m
+}
+
+func (m *mirror) run() {
+	conn, err := net.DialTimeout("tcp", m.f.rule.Mirror, mirrorDialTimeout)
+	if err != nil {
+		atomic.AddUint64(&m.f.mirrorErrors, 1)
+		for range m.ch {
+		}
+		return
+	}
+	defer conn.Close()
+	go io.Copy(io.Discard, conn)
+
+	failed := false
+	for p := range m.ch {
+		if failed {
+			continue
+		}
+		conn.SetWriteDeadline(time.Now().Add(mirrorWriteTimeout))
+		if _, err := conn.Write(p); err != nil {
+			atomic.AddUint64(&m.f.mirrorErrors, 1)
+			failed = true
+			conn.Close()
+		}
+	}
+}
+
+func (m *mirror) send(p []byte) {
+	buf := make([]byte, len(p))
+	copy(buf, p)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return
+	}
+	select {
+	case m.ch <- buf:
+	default:
+		atomic.AddUint64(&m.f.mirrorDrops, 1)
+	}
+}
+
+func (m *mirror) close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.closed {
+		m.closed = true
+		close(m.ch)
+	}
+}
+
+// mirrorConn 在读取客户端数据时将其复制到影子上游
+type mirrorConn struct {
+	net.Conn
+	m *mirror
+}
+
+func (c *mirrorConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.m.send(p[:n])
+	}
+	return n, err
+}
+
+func (c *mirrorConn) Close() error {
+	c.m.close()
+	return c.Conn.Close()
+}
+
+func (c *mirrorConn) NetConn() net.Conn {
+	return c.Conn
+}
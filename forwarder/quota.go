@@ -0,0 +1,168 @@
+package forwarder
+
+import (
+	"fmt"
+	"gopf/config"
+	"gopf/hooks"
+	"gopf/stats"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultThrottle = 16 * 1024
+
+// quotaUsage 当前周期的配额用量，周期切换时由 updateStats 清零
+type quotaUsage struct {
+	mu       sync.Mutex
+	day      string // 当前日，2006-01-02
+	month    string // 当前月，2006-01
+	dayBytes uint64
+	monBytes uint64
+	dayConns uint64
+	exceeded atomic.Bool // 流量配额已用尽
+	full     atomic.Bool // 今日连接数已达上限
+}
+
+// SeedQuota 以统计存储中今日和本月的用量作为配额起点，需在 Start 之前调用。
+// 未配置统计存储时配额用量只在本次运行内累计。
+func (f *Forwarder) SeedQuota(store *stats.Store) {
+	if store == nil || f.rule.Quota == nil {
+		return
+	}
+
+	q := &f.quota
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	st := store.Get(f.rule.ID)
+	day, month := st.Day(now), st.Month(now)
+	q.day, q.month = now.Format("2006-01-02"), now.Format("2006-01")
+	atomic.StoreUint64(&q.dayBytes, day.BytesSent+day.BytesRecv)
+	atomic.StoreUint64(&q.monBytes, month.BytesSent+month.BytesRecv)
+	atomic.StoreUint64(&q.dayConns, day.Connections)
+	f.checkQuota()
+}
+
+// QuotaExceeded 返回规则是否已超出配额
+func (f *Forwarder) QuotaExceeded() bool {
+	return f.quota.exceeded.Load() || f.quota.full.Load()
+}
+
+func validateQuota(q *config.QuotaConfig) error {
+	if q == nil {
+		return nil
+	}
+	switch q.Action {
+	case "", config.QuotaStop, config.QuotaThrottle:
+		return nil
+	}
+	return fmt.Errorf("未知的配额处理方式: %s", q.Action)
+}
+
+// overQuota 判断流量配额是否已用尽
+func (f *Forwarder) overQuota() bool {
+	q := f.rule.Quota
+	if q == nil {
+		return false
+	}
+	return q.Daily > 0 && atomic.LoadUint64(&f.quota.dayBytes) >= q.Daily ||
+		q.Monthly > 0 && atomic.LoadUint64(&f.quota.monBytes) >= q.Monthly
+}
+
+// connsFull 判断今日连接数是否已达上限，连接数配额只限制新连接
+func (f *Forwarder) connsFull() bool {
+	q := f.rule.Quota
+	return q != nil && q.Connections > 0 && atomic.LoadUint64(&f.quota.dayConns) >= q.Connections
+}
+
+func (f *Forwarder) checkQuota() {
+	f.setExceeded(f.overQuota())
+	f.setFull(f.connsFull())
+}
+
+// setExceeded 更新流量配额状态，刚用尽时触发钩子
+func (f *Forwarder) setExceeded(v bool) {
+	if !f.quota.exceeded.Swap(v) && v {
+		f.emit(hooks.QuotaExceeded, "流量配额已用尽", "quota", "traffic", "action", f.quotaAction())
+	}
+}
+
+// setFull 更新连接数配额状态，刚达到上限时触发钩子
+func (f *Forwarder) setFull(v bool) {
+	if !f.quota.full.Swap(v) && v {
+		f.emit(hooks.QuotaExceeded, "今日连接数已达上限", "quota", "connections", "action", config.QuotaStop)
+	}
+}
+
+func (f *Forwarder) quotaAction() string {
+	if q := f.rule.Quota; q != nil && q.Action != "" {
+		return q.Action
+	}
+	return config.QuotaStop
+}
+
+// rollQuota 在跨日或跨月时清零对应的用量，使超出配额的规则自动恢复
+func (f *Forwarder) rollQuota(now time.Time) {
+	q := &f.quota
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	day, month := now.Format("2006-01-02"), now.Format("2006-01")
+	if q.day != day {
+		q.day = day
+		atomic.StoreUint64(&q.dayBytes, 0)
+		atomic.StoreUint64(&q.dayConns, 0)
+	}
+	if q.month != month {
+		q.month = month
+		atomic.StoreUint64(&q.monBytes, 0)
+	}
+	f.checkQuota()
+}
+
+func (f *Forwarder) quotaBytes(n int) {
+	if f.rule.Quota == nil {
+		return
+	}
+	atomic.AddUint64(&f.quota.dayBytes, uint64(n))
+	atomic.AddUint64(&f.quota.monBytes, uint64(n))
+	if f.overQuota() {
+		f.setExceeded(true)
+	}
+}
+
+// admitConn 判断是否接受新连接，接受时计入今日连接数
+func (f *Forwarder) admitConn() bool {
+	q := f.rule.Quota
+	if q == nil {
+		return true
+	}
+	if f.quota.exceeded.Load() && q.Action != config.QuotaThrottle {
+		return false
+	}
+	if n := atomic.AddUint64(&f.quota.dayConns, 1); q.Connections > 0 && n > q.Connections {
+		atomic.AddUint64(&f.quota.dayConns, ^uint64(0))
+		f.setFull(true)
+		return false
+	}
+	return true
+}
+
+// enforceQuota 在转发数据块前执行超额处理，返回 false 表示应断开连接
+func (f *Forwarder) enforceQuota(limiter *rateLimiter, n int) bool {
+	if !f.quota.exceeded.Load() {
+		return true
+	}
+	q := f.rule.Quota
+	if q.Action != config.QuotaThrottle {
+		return false
+	}
+	rate := q.Throttle
+	if rate <= 0 {
+		rate = defaultThrottle
+	}
+	limiter.wait(n, rate)
+	return true
+}
@@ -0,0 +1,73 @@
+package forwarder
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// rateHistorySize 保留的每秒速率样本数（5 分钟）
+const rateHistorySize = 300
+
+// rateHistory 每秒速率的环形缓冲区
+type rateHistory struct {
+	mu       sync.Mutex
+	sent     [rateHistorySize]uint64
+	recv     [rateHistorySize]uint64
+	next     int
+	count    int
+	lastSent uint64
+	lastRecv uint64
+}
+
+// sample 根据累计字节数计算最近一秒的速率并记录，返回本次的速率
+func (h *rateHistory) sample(sent, recv uint64) (uint64, uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// 统计被清空后累计值会变小，此时整个累计值都属于这一秒
+	rateSent, rateRecv := sent, recv
+	if sent >= h.lastSent {
+		rateSent = sent - h.lastSent
+	}
+	if recv >= h.lastRecv {
+		rateRecv = recv - h.lastRecv
+	}
+	h.lastSent, h.lastRecv = sent, recv
+
+	h.sent[h.next] = rateSent
+	h.recv[h.next] = rateRecv
+	h.next = (h.next + 1) % rateHistorySize
+	if h.count < rateHistorySize {
+		h.count++
+	}
+	return rateSent, rateRecv
+}
+
+func (h *rateHistory) reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.next, h.count = 0, 0
+	h.lastSent, h.lastRecv = 0, 0
+}
+
+// RateHistory 返回最近 5 分钟每秒的发送和接收速率（字节/秒），按时间从旧到新排列
+func (f *Forwarder) RateHistory() (sent, recv []uint64) {
+	h := &f.rates
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sent = make([]uint64, h.count)
+	recv = make([]uint64, h.count)
+	start := (h.next - h.count + rateHistorySize) % rateHistorySize
+	for i := 0; i < h.count; i++ {
+		sent[i] = h.sent[(start+i)%rateHistorySize]
+		recv[i] = h.recv[(start+i)%rateHistorySize]
+	}
+	return sent, recv
+}
+
+// sampleRates 记录最近一秒的速率并返回
+func (f *Forwarder) sampleRates() (uint64, uint64) {
+	return f.rates.sample(atomic.LoadUint64(&f.bytesSent), atomic.LoadUint64(&f.bytesRecv))
+}
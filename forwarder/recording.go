@@ -0,0 +1,37 @@
+package forwarder
+
+import (
+	"gopf/record"
+	"net"
+)
+
+// recordConn 将客户端一侧的双向数据写入录制文件
+type recordConn struct {
+	net.Conn
+	w *record.Writer
+}
+
+func (c *recordConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.w.Write(record.ClientToUpstream, p[:n])
+	}
+	return n, err
+}
+
+func (c *recordConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.w.Write(record.UpstreamToClient, p[:n])
+	}
+	return n, err
+}
+
+func (c *recordConn) Close() error {
+	c.w.Close()
+	return c.Conn.Close()
+}
+
+func (c *recordConn) NetConn() net.Conn {
+	return c.Conn
+}
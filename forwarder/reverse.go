@@ -0,0 +1,459 @@
+package forwarder
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"gopf/hooks"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 反向转发控制协议，基于行的文本协议，每行以 \n 结尾：
+//
+//	客户端 -> 中继（控制连接）：GOPF/1 REGISTER <公网端口> <令牌> [deflate]
+//	中继 -> 客户端：            OK | ERR <原因>
+//	中继 -> 客户端：            CONN <id>    有新的公网连接等待认领
+//	中继 -> 客户端：            PING         心跳，客户端回复 PONG
+//	客户端 -> 中继（数据连接）：GOPF/1 DATA <id> <令牌>
+//
+// 数据连接发送握手行后即成为原始字节流，与对应的公网连接对接。
+// 注册时带有 deflate 标志的隧道，其数据连接在握手行之后使用 DEFLATE 压缩。
+// 中继必须设置令牌，并且只开放 public_ports 范围内的端口。客户端令牌为空时以 "-" 代替。
+const (
+	protoVersion      = "GOPF/1"
+	flagDeflate       = "deflate"
+	handshakeTimeout  = 10 * time.Second
+	pendingTimeout    = 10 * time.Second
+	heartbeatInterval = 30 * time.Second
+	reconnectDelay    = 3 * time.Second
+)
+
+// bufferedConn 保留读取握手行时 bufio.Reader 中已缓冲的数据
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c *bufferedConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func readLine(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("空的控制消息")
+	}
+	return fields, nil
+}
+
+func tokenField(token string) string {
+	if token == "" {
+		return "-"
+	}
+	return token
+}
+
+// tunnel 中继端的一条已注册隧道
+type tunnel struct {
+	ctrl     net.Conn
+	listener net.Listener
+	compress bool
+	wmu      sync.Mutex
+	closed   chan struct{}
+	once     sync.Once
+}
+
+func (t *tunnel) send(format string, args ...any) error {
+	t.wmu.Lock()
+	defer t.wmu.Unlock()
+
+	t.ctrl.SetWriteDeadline(time.Now().Add(handshakeTimeout))
+	_, err := fmt.Fprintf(t.ctrl, format+"\n", args...)
+	return err
+}
+
+func (t *tunnel) close() {
+	t.once.Do(func() {
+		close(t.closed)
+		t.listener.Close()
+		t.ctrl.Close()
+	})
+}
+
+// relay 中继端，管理已注册的隧道和等待认领的公网连接
+type relay struct {
+	f       *Forwarder
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[uint64]pendingConn
+	tunnels map[*tunnel]struct{}
+}
+
+// pendingConn 等待客户端认领的公网连接
+type pendingConn struct {
+	conn     net.Conn
+	compress bool
+}
+
+func newRelay(f *Forwarder) *relay {
+	return &relay{
+		f:       f,
+		pending: make(map[uint64]pendingConn),
+		tunnels: make(map[*tunnel]struct{}),
+	}
+}
+
+func (r *relay) handleControl(conn net.Conn) {
+	br := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	fields, err := readLine(br)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	if len(fields) < 4 || fields[0] != protoVersion {
+		fmt.Fprintf(conn, "ERR 不支持的协议\n")
+		conn.Close()
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(fields[3]), []byte(tokenField(r.f.rule.Token))) != 1 {
+		r.f.log.Warn("中继认证失败", "event", "auth_failed", "client", conn.RemoteAddr().String())
+		fmt.Fprintf(conn, "ERR 认证失败\n")
+		conn.Close()
+		return
+	}
+
+	switch fields[1] {
+	case "REGISTER":
+		port, err := strconv.Atoi(fields[2])
+		lo, hi, _ := r.f.rule.PublicPortRange()
+		if err != nil || port < lo || port > hi {
+			r.f.log.Warn("拒绝开放范围外的公网端口", "event", "register_failed", "client", conn.RemoteAddr().String(), "public_port", fields[2])
+			fmt.Fprintf(conn, "ERR 公网端口不在允许的范围 %s 内\n", r.f.rule.PublicPorts)
+			conn.Close()
+			return
+		}
+		r.register(conn, br, port, len(fields) > 4 && fields[4] == flagDeflate)
+	case "DATA":
+		id, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			conn.Close()
+			return
+		}
+		r.attach(&bufferedConn{Conn: conn, r: br}, id)
+	default:
+		fmt.Fprintf(conn, "ERR 未知命令\n")
+		conn.Close()
+	}
+}
+
+// register 为客户端开放公网端口，直到控制连接断开
+func (r *relay) register(ctrl net.Conn, br *bufio.Reader, port int, compress bool) {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		r.f.log.Warn("开放公网端口失败", "event", "register_failed", "client", ctrl.RemoteAddr().String(), "public_port", port, "err", err)
+		fmt.Fprintf(ctrl, "ERR %v\n", err)
+		ctrl.Close()
+		return
+	}
+
+	t := &tunnel{ctrl: ctrl, listener: listener, compress: compress, closed: make(chan struct{})}
+	r.mu.Lock()
+	select {
+	case <-r.f.done:
+		r.mu.Unlock()
+		t.close()
+		return
+	default:
+	}
+	r.tunnels[t] = struct{}{}
+	r.mu.Unlock()
+
+	r.f.log.Info("隧道已注册", "event", "tunnel_up", "client", ctrl.RemoteAddr().String(), "public_port", port)
+	defer func() {
+		r.mu.Lock()
+		delete(r.tunnels, t)
+		r.mu.Unlock()
+		t.close()
+		r.f.log.Info("隧道已注销", "event", "tunnel_down", "client", ctrl.RemoteAddr().String(), "public_port", port)
+	}()
+
+	if err := t.send("OK"); err != nil {
+		return
+	}
+	go r.acceptPublic(t)
+	go r.heartbeat(t)
+
+	// 读取客户端的心跳回复，控制连接断开即注销隧道
+	for {
+		ctrl.SetReadDeadline(time.Now().Add(3 * heartbeatInterval))
+		if _, err := readLine(br); err != nil {
+			return
+		}
+	}
+}
+
+func (r *relay) acceptPublic(t *tunnel) {
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		r.mu.Lock()
+		r.nextID++
+		id := r.nextID
+		r.pending[id] = pendingConn{conn: conn, compress: t.compress}
+		r.mu.Unlock()
+
+		if err := t.send("CONN %d", id); err != nil {
+			if p, ok := r.take(id); ok {
+				p.conn.Close()
+			}
+			t.close()
+			return
+		}
+
+		// 客户端未及时认领的连接直接关闭
+		time.AfterFunc(pendingTimeout, func() {
+			if p, ok := r.take(id); ok {
+				p.conn.Close()
+			}
+		})
+	}
+}
+
+func (r *relay) heartbeat(t *tunnel) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.closed:
+			return
+		case <-ticker.C:
+			if err := t.send("PING"); err != nil {
+				t.close()
+				return
+			}
+		}
+	}
+}
+
+func (r *relay) take(id uint64) (pendingConn, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	p, ok := r.pending[id]
+	delete(r.pending, id)
+	return p, ok
+}
+
+// attach 将客户端的数据连接与等待中的公网连接对接
+func (r *relay) attach(data net.Conn, id uint64) {
+	p, ok := r.take(id)
+	if !ok {
+		data.Close()
+		return
+	}
+
+	f := r.f
+	if !f.admitConn() {
+		p.conn.Close()
+		data.Close()
+		return
+	}
+
+	if p.compress {
+		data = compressLink(data, &f.wireRecv, &f.wireSent)
+	}
+	s := f.newSession(p.conn, data)
+	go f.pipe(s, true)
+	go f.pipe(s, false)
+}
+
+func (r *relay) close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for t := range r.tunnels {
+		t.close()
+	}
+	for id, p := range r.pending {
+		p.conn.Close()
+		delete(r.pending, id)
+	}
+}
+
+func (f *Forwarder) relayAddr() string {
+	return net.JoinHostPort(f.rule.RemoteHost, strconv.Itoa(f.rule.RemotePort))
+}
+
+// startReverse 向中继注册隧道，首次注册失败直接返回错误，之后断线自动重连
+func (f *Forwarder) startReverse() error {
+	ctrl, br, err := f.register()
+	if err != nil {
+		return err
+	}
+
+	f.ctrl = ctrl
+	go f.serveReverse(ctrl, br)
+	return nil
+}
+
+func (f *Forwarder) register() (net.Conn, *bufio.Reader, error) {
+	conn, err := net.DialTimeout("tcp", f.relayAddr(), handshakeTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	line := fmt.Sprintf("%s REGISTER %d %s", protoVersion, f.rule.PublicPort, tokenField(f.rule.Token))
+	if f.rule.Compress != "" {
+		line += " " + flagDeflate
+	}
+
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	if _, err := fmt.Fprintf(conn, "%s\n", line); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	fields, err := readLine(br)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if fields[0] != "OK" {
+		conn.Close()
+		return nil, nil, fmt.Errorf("中继拒绝注册: %s", strings.Join(fields[1:], " "))
+	}
+
+	conn.SetDeadline(time.Time{})
+	return conn, br, nil
+}
+
+func (f *Forwarder) serveReverse(ctrl net.Conn, br *bufio.Reader) {
+	for {
+		f.readControl(ctrl, br)
+		f.upstreamDown.Store(true)
+		select {
+		case <-f.done:
+			return
+		default:
+		}
+		f.log.Warn("与中继的连接已断开，正在重连", "event", "upstream_down", "relay", f.relayAddr())
+		f.emit(hooks.UpstreamDown, "与中继的连接已断开", "relay", f.relayAddr())
+
+		for {
+			select {
+			case <-f.done:
+				return
+			case <-time.After(reconnectDelay):
+			}
+
+			c, b, err := f.register()
+			if err != nil {
+				f.log.Debug("重新注册失败", "event", "register_failed", "relay", f.relayAddr(), "err", err)
+				continue
+			}
+
+			f.mu.Lock()
+			select {
+			case <-f.done:
+				f.mu.Unlock()
+				c.Close()
+				return
+			default:
+			}
+			f.ctrl = c
+			f.mu.Unlock()
+			f.upstreamDown.Store(false)
+			f.log.Info("已重新注册到中继", "event", "upstream_up", "relay", f.relayAddr())
+			f.emit(hooks.UpstreamUp, "已重新注册到中继", "relay", f.relayAddr())
+
+			ctrl, br = c, b
+			break
+		}
+	}
+}
+
+// readControl 处理中继下发的消息，直到控制连接断开
+func (f *Forwarder) readControl(ctrl net.Conn, br *bufio.Reader) {
+	defer ctrl.Close()
+
+	for {
+		ctrl.SetReadDeadline(time.Now().Add(3 * heartbeatInterval))
+		fields, err := readLine(br)
+		if err != nil {
+			return
+		}
+
+		switch fields[0] {
+		case "CONN":
+			if len(fields) < 2 {
+				continue
+			}
+			id, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				continue
+			}
+			go f.openReverse(id)
+		case "PING":
+			ctrl.SetWriteDeadline(time.Now().Add(handshakeTimeout))
+			if _, err := fmt.Fprintf(ctrl, "PONG\n"); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// openReverse 连接本地服务，并通过新的数据连接认领公网连接
+func (f *Forwarder) openReverse(id uint64) {
+	if !f.admitConn() {
+		return
+	}
+
+	start := time.Now()
+	target := net.JoinHostPort("localhost", strconv.Itoa(f.rule.LocalPort))
+	local, err := net.DialTimeout("tcp", target, handshakeTimeout)
+	f.dialResult(target, err)
+	if err != nil {
+		f.log.Warn("连接本地服务失败", "event", "dial_failed", "upstream", target, "err", err)
+		return
+	}
+	f.dialTime.observe(time.Since(start))
+
+	data, err := net.DialTimeout("tcp", f.relayAddr(), handshakeTimeout)
+	if err != nil {
+		f.log.Warn("连接中继失败", "event", "dial_failed", "relay", f.relayAddr(), "err", err)
+		local.Close()
+		return
+	}
+	if _, err := fmt.Fprintf(data, "%s DATA %d %s\n", protoVersion, id, tokenField(f.rule.Token)); err != nil {
+		local.Close()
+		data.Close()
+		return
+	}
+
+	if f.rule.Compress != "" {
+		data = compressLink(data, &f.wireSent, &f.wireRecv)
+	}
+	s := f.newSession(data, local)
+	go f.pipe(s, true)
+	go f.pipe(s, false)
+}
@@ -0,0 +1,85 @@
+package forwarder
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats 转发器本次运行的统计，每秒同步一次，重启后从零开始
+type Stats struct {
+	BytesSent    uint64  `json:"bytes_sent"`
+	BytesRecv    uint64  `json:"bytes_recv"`
+	RateSent     uint64  `json:"rate_sent"`
+	RateRecv     uint64  `json:"rate_recv"`
+	WireSent     uint64  `json:"wire_sent"`
+	WireRecv     uint64  `json:"wire_recv"`
+	Connections  uint64  `json:"connections"`
+	TotalConns   uint64  `json:"total_connections"`
+	DialFailures uint64  `json:"dial_failures"`
+	UpstreamDown bool    `json:"upstream_down"`
+	MirrorDrops  uint64  `json:"mirror_drops"`
+	MirrorErrors uint64  `json:"mirror_errors"`
+	FaultDelays  uint64  `json:"fault_delays"`
+	FaultResets  uint64  `json:"fault_resets"`
+	FaultCorrupt uint64  `json:"fault_corrupt"`
+	FaultTrunc   uint64  `json:"fault_truncations"`
+	QuotaDay     uint64  `json:"quota_day"`         // 今日已用流量（双向合计）
+	QuotaMonth   uint64  `json:"quota_month"`       // 本月已用流量（双向合计）
+	QuotaConns   uint64  `json:"quota_connections"` // 今日已用连接数
+	OverQuota    bool    `json:"over_quota"`
+	DialLatency  Latency `json:"dial_latency"`
+	TLSLatency   Latency `json:"tls_latency"`
+	TTFB         Latency `json:"ttfb"`
+	ChunksSent   uint64  `json:"chunks_sent"`
+	ChunksRecv   uint64  `json:"chunks_recv"`
+	LastActive   int64   `json:"last_active,omitempty"` // Unix 秒
+}
+
+// Latency 一类延迟的分位数统计
+type Latency struct {
+	P50   time.Duration `json:"p50"`
+	P95   time.Duration `json:"p95"`
+	P99   time.Duration `json:"p99"`
+	Sum   time.Duration `json:"sum"`
+	Count uint64        `json:"count"`
+}
+
+// Stats 返回最近一次同步的统计
+func (f *Forwarder) Stats() Stats {
+	if s := f.published.Load(); s != nil {
+		return *s
+	}
+	return Stats{}
+}
+
+// publish 将当前计数连同最近一秒的速率同步到统计快照
+func (f *Forwarder) publish(rateSent, rateRecv uint64) {
+	f.published.Store(&Stats{
+		BytesSent:    atomic.LoadUint64(&f.bytesSent),
+		BytesRecv:    atomic.LoadUint64(&f.bytesRecv),
+		RateSent:     rateSent,
+		RateRecv:     rateRecv,
+		WireSent:     atomic.LoadUint64(&f.wireSent),
+		WireRecv:     atomic.LoadUint64(&f.wireRecv),
+		Connections:  atomic.LoadUint64(&f.connections),
+		TotalConns:   atomic.LoadUint64(&f.totalConns),
+		DialFailures: atomic.LoadUint64(&f.dialFailures),
+		UpstreamDown: f.upstreamDown.Load(),
+		MirrorDrops:  atomic.LoadUint64(&f.mirrorDrops),
+		MirrorErrors: atomic.LoadUint64(&f.mirrorErrors),
+		FaultDelays:  atomic.LoadUint64(&f.faultDelays),
+		FaultResets:  atomic.LoadUint64(&f.faultResets),
+		FaultCorrupt: atomic.LoadUint64(&f.faultCorruptions),
+		FaultTrunc:   atomic.LoadUint64(&f.faultTruncations),
+		QuotaDay:     atomic.LoadUint64(&f.quota.dayBytes),
+		QuotaMonth:   atomic.LoadUint64(&f.quota.monBytes),
+		QuotaConns:   atomic.LoadUint64(&f.quota.dayConns),
+		OverQuota:    f.QuotaExceeded(),
+		DialLatency:  f.dialTime.snapshot(),
+		TLSLatency:   f.tlsTime.snapshot(),
+		TTFB:         f.ttfb.snapshot(),
+		ChunksSent:   atomic.LoadUint64(&f.chunksSent),
+		ChunksRecv:   atomic.LoadUint64(&f.chunksRecv),
+		LastActive:   atomic.LoadInt64(&f.lastActive),
+	})
+}
@@ -0,0 +1,60 @@
+package forwarder
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// TapEvent 旁路观察到的一段转发数据
+type TapEvent struct {
+	Conn       uint64
+	Client     string
+	FromClient bool
+	Time       time.Time
+	Data       []byte
+}
+
+// Tap 转发数据的旁路观察者。观察者处理不及时时事件会被丢弃，不影响转发。
+type Tap struct {
+	ch    chan TapEvent
+	drops uint64
+}
+
+// AttachTap 挂载观察者，同一时间只有一个观察者，新的会替换旧的
+func (f *Forwarder) AttachTap(size int) *Tap {
+	t := &Tap{ch: make(chan TapEvent, size)}
+	f.tap.Store(t)
+	return t
+}
+
+// DetachTap 卸载观察者，未挂载时无任何开销
+func (f *Forwarder) DetachTap(t *Tap) {
+	f.tap.CompareAndSwap(t, nil)
+}
+
+// Events 返回观察到的事件
+func (t *Tap) Events() <-chan TapEvent {
+	return t.ch
+}
+
+// Drops 返回因观察者处理不及时而丢弃的事件数
+func (t *Tap) Drops() uint64 {
+	return atomic.LoadUint64(&t.drops)
+}
+
+func (t *Tap) emit(s *session, fromClient bool, p []byte) {
+	data := make([]byte, len(p))
+	copy(data, p)
+
+	select {
+	case t.ch <- TapEvent{
+		Conn:       s.id,
+		Client:     s.client.RemoteAddr().String(),
+		FromClient: fromClient,
+		Time:       time.Now(),
+		Data:       data,
+	}:
+	default:
+		atomic.AddUint64(&t.drops, 1)
+	}
+}
@@ -0,0 +1,335 @@
+// Package hooks 在规则和连接事件发生时通知外部系统。
+//
+// 每个钩子要么向 URL POST 一份 JSON，要么执行一条本地命令并通过 GOPF_* 环境变量传入事件数据。
+// 事件先进入有界队列，由后台协程执行钩子；队列满时直接丢弃，因此钩子再慢也不会阻塞转发。
+// 控制接口等进程内的使用方可以通过 Subscribe 接收全部事件。
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"gopf/config"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 事件类型
+const (
+	RuleStarted   = "rule_started"
+	RuleStopped   = "rule_stopped"
+	RuleFailed    = "rule_failed"
+	UpstreamDown  = "upstream_down"
+	UpstreamUp    = "upstream_up"
+	QuotaExceeded = "quota_exceeded"
+	ConnOpen      = "conn_open"
+	ConnClose     = "conn_close"
+)
+
+// 未指定 events 时订阅的事件，连接事件较频繁，需要显式订阅
+var defaultEvents = []string{RuleStarted, RuleStopped, RuleFailed, UpstreamDown, UpstreamUp, QuotaExceeded}
+
+var allEvents = append(slices.Clone(defaultEvents), ConnOpen, ConnClose)
+
+const (
+	DefaultTimeout = 10 * time.Second
+	queueSize      = 256
+	workers        = 4
+)
+
+// Event 一次事件，同时也是 Webhook 的请求体
+type Event struct {
+	Event   string            `json:"event"`
+	Rule    string            `json:"rule"`
+	Time    time.Time         `json:"time"`
+	Message string            `json:"message"`
+	Data    map[string]string `json:"data,omitempty"`
+	Text    string            `json:"text"` // 可读的摘要，可直接用于 Slack 等聊天工具的 Incoming Webhook
+}
+
+type job struct {
+	hook  *config.HookConfig
+	event Event
+}
+
+// Dispatcher 将事件分发给匹配的钩子和订阅者，nil 值可以安全使用（不做任何事）
+type Dispatcher struct {
+	hooks   []config.HookConfig
+	log     *slog.Logger
+	client  *http.Client
+	mu      sync.RWMutex
+	closed  bool
+	queue   chan job
+	wg      sync.WaitGroup
+	dropped atomic.Uint64
+	subs    map[chan Event]struct{}
+	nsubs   atomic.Int32
+}
+
+// New 校验钩子配置并启动后台协程，没有配置钩子时只分发给订阅者
+func New(hooks []config.HookConfig, log *slog.Logger) (*Dispatcher, error) {
+	for i, h := range hooks {
+		if (h.URL == "") == (h.Command == "") {
+			return nil, fmt.Errorf("钩子 #%d: url 和 command 必须且只能设置一个", i+1)
+		}
+		for _, e := range h.Events {
+			if !slices.Contains(allEvents, e) {
+				return nil, fmt.Errorf("钩子 #%d: 未知的事件: %s", i+1, e)
+			}
+		}
+	}
+
+	d := &Dispatcher{
+		hooks:  hooks,
+		log:    log,
+		client: &http.Client{},
+		queue:  make(chan job, queueSize),
+		subs:   make(map[chan Event]struct{}),
+	}
+	if len(hooks) == 0 {
+		return d, nil
+	}
+	for range workers {
+		d.wg.Add(1)
+		go d.worker()
+	}
+	return d, nil
+}
+
+func matches(h *config.HookConfig, event, rule string) bool {
+	events := h.Events
+	if len(events) == 0 {
+		events = defaultEvents
+	}
+	return slices.Contains(events, event) && (len(h.Rules) == 0 || slices.Contains(h.Rules, rule))
+}
+
+// Wants 判断是否有钩子或订阅者需要该事件，调用方可据此跳过构造事件数据
+func (d *Dispatcher) Wants(event, rule string) bool {
+	if d == nil {
+		return false
+	}
+	if d.nsubs.Load() > 0 {
+		return true
+	}
+	for i := range d.hooks {
+		if matches(&d.hooks[i], event, rule) {
+			return true
+		}
+	}
+	return false
+}
+
+// Emit 将事件放入队列后立即返回，队列已满时丢弃事件
+func (d *Dispatcher) Emit(e Event) {
+	if d == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.Text = summary(e)
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return
+	}
+	for ch := range d.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+	for i := range d.hooks {
+		if !matches(&d.hooks[i], e.Event, e.Rule) {
+			continue
+		}
+		select {
+		case d.queue <- job{hook: &d.hooks[i], event: e}:
+		default:
+			if n := d.dropped.Add(1); n == 1 || n%100 == 0 {
+				d.log.Warn("钩子队列已满，丢弃事件", "event", "hook_dropped", "hook_event", e.Event, "dropped", n)
+			}
+		}
+	}
+}
+
+// Subscribe 订阅全部事件，接收不及时的事件会被丢弃。
+// 调用返回的函数取消订阅，Dispatcher 关闭时通道也会被关闭。
+func (d *Dispatcher) Subscribe(buf int) (<-chan Event, func()) {
+	ch := make(chan Event, buf)
+	if d == nil {
+		close(ch)
+		return ch, func() {}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	d.subs[ch] = struct{}{}
+	d.nsubs.Add(1)
+
+	return ch, func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if _, ok := d.subs[ch]; ok {
+			delete(d.subs, ch)
+			d.nsubs.Add(-1)
+			close(ch)
+		}
+	}
+}
+
+// Close 停止接收事件，并在 timeout 内等待已排队的钩子执行完
+func (d *Dispatcher) Close(timeout time.Duration) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return
+	}
+	d.closed = true
+	close(d.queue)
+	for ch := range d.subs {
+		delete(d.subs, ch)
+		close(ch)
+	}
+	d.nsubs.Store(0)
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	}
+}
+
+func (d *Dispatcher) worker() {
+	defer d.wg.Done()
+	for j := range d.queue {
+		if err := d.run(j.hook, j.event); err != nil {
+			d.log.Warn("钩子执行失败", "event", "hook_failed", "hook_event", j.event.Event,
+				"rule", j.event.Rule, "target", target(j.hook), "err", err)
+		}
+	}
+}
+
+func target(h *config.HookConfig) string {
+	if h.URL != "" {
+		return h.URL
+	}
+	return h.Command
+}
+
+func (d *Dispatcher) run(h *config.HookConfig, e Event) error {
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if h.URL != "" {
+		return d.post(ctx, h, payload)
+	}
+	return runCommand(ctx, h.Command, e, payload)
+}
+
+func (d *Dispatcher) post(ctx context.Context, h *config.HookConfig, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range h.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("HTTP %s", resp.Status)
+	}
+	return nil
+}
+
+// runCommand 通过系统 shell 执行命令，事件 JSON 同时写入标准输入
+func runCommand(ctx context.Context, command string, e Event, payload []byte) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(), Environ(e)...)
+	cmd.Stdin = bytes.NewReader(payload)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%v: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// Environ 返回传给命令的环境变量：GOPF_EVENT、GOPF_RULE、GOPF_TIME、GOPF_MESSAGE，
+// 以及 Data 中每一项对应的 GOPF_<KEY>（键名转为大写）
+func Environ(e Event) []string {
+	env := []string{
+		"GOPF_EVENT=" + e.Event,
+		"GOPF_RULE=" + e.Rule,
+		"GOPF_TIME=" + e.Time.Format(time.RFC3339),
+		"GOPF_MESSAGE=" + e.Message,
+	}
+	for _, k := range sortedKeys(e.Data) {
+		env = append(env, "GOPF_"+strings.ToUpper(k)+"="+e.Data[k])
+	}
+	return env
+}
+
+func summary(e Event) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[gopf] %s: %s (%s)", e.Rule, e.Message, e.Event)
+	for _, k := range sortedKeys(e.Data) {
+		fmt.Fprintf(&b, " %s=%s", k, e.Data[k])
+	}
+	return b.String()
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
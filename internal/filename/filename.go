@@ -0,0 +1,32 @@
+// Package filename 生成录制、抓包等按规则保存的文件的文件名。
+package filename
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var seq uint64
+
+// New 返回以规则名称和时间命名的文件名，ext 为包含点号的扩展名。
+// 时间精确到毫秒并附带进程内递增的序号，同一秒内多次创建也不会重名。
+func New(rule string, t time.Time, ext string) string {
+	return fmt.Sprintf("%s-%s-%d%s", sanitize(rule), t.Format("20060102-150405.000"), atomic.AddUint64(&seq, 1), ext)
+}
+
+// sanitize 将规则名称转换为可用于文件名的形式
+func sanitize(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|', ' ':
+			return '_'
+		}
+		return r
+	}, name)
+	if name == "" {
+		return "rule"
+	}
+	return name
+}
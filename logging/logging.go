@@ -0,0 +1,195 @@
+// Package logging 基于 log/slog 的结构化日志。
+//
+// 日志同时写入控制台或文件（text/json，按大小轮转）以及内存中的环形缓冲区，
+// 后者供界面的日志面板实时查看。每条规则可以单独设置日志级别。
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gopf/config"
+	"io"
+	"log/slog"
+)
+
+const (
+	DefaultMaxSize    = 10 * 1024 * 1024
+	DefaultMaxBackups = 3
+	DefaultRingSize   = 1000
+)
+
+// Logger 持有日志输出，并为各规则创建带级别的 slog.Logger
+type Logger struct {
+	handler slog.Handler // 不做级别过滤的输出
+	level   slog.Level   // 全局级别
+	ring    *Ring
+	file    *RotatingFile
+}
+
+// New 按配置创建日志。console 为 nil 时不输出到控制台（界面运行期间），
+// 未配置文件时日志只保留在环形缓冲区中。
+func New(cfg *config.LogConfig, console io.Writer) (*Logger, error) {
+	if cfg == nil {
+		cfg = &config.LogConfig{}
+	}
+
+	level, err := ParseLevel(cfg.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Logger{
+		level: level,
+		ring:  NewRing(DefaultRingSize),
+	}
+	handlers := []slog.Handler{&ringHandler{ring: l.ring}}
+
+	var writers []io.Writer
+	if console != nil {
+		writers = append(writers, console)
+	}
+	if cfg.File != "" {
+		maxSize, backups := cfg.MaxSize, DefaultMaxBackups
+		if maxSize <= 0 {
+			maxSize = DefaultMaxSize
+		}
+		if cfg.MaxBackups != nil {
+			backups = *cfg.MaxBackups
+		}
+		if l.file, err = OpenRotating(cfg.File, maxSize, backups); err != nil {
+			return nil, err
+		}
+		writers = append(writers, l.file)
+	}
+
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	for _, w := range writers {
+		switch cfg.Format {
+		case "", "text":
+			handlers = append(handlers, slog.NewTextHandler(w, opts))
+		case "json":
+			handlers = append(handlers, slog.NewJSONHandler(w, opts))
+		default:
+			l.Close()
+			return nil, fmt.Errorf("未知的日志格式: %s", cfg.Format)
+		}
+	}
+
+	l.handler = fanout(handlers)
+	return l, nil
+}
+
+// ParseLevel 解析 debug/info/warn/error，空字符串视为 info
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("未知的日志级别: %s", s)
+	}
+	return level, nil
+}
+
+// Slog 返回使用全局级别的 slog.Logger
+func (l *Logger) Slog() *slog.Logger {
+	return slog.New(&levelHandler{level: l.level, next: l.handler})
+}
+
+// Rule 返回规则使用的 slog.Logger，带有 rule 属性，规则设置了 log_level 时使用该级别
+func (l *Logger) Rule(rule *config.ForwardRule) *slog.Logger {
+	level := l.level
+	if rule.LogLevel != "" {
+		if lv, err := ParseLevel(rule.LogLevel); err == nil {
+			level = lv
+		}
+	}
+	h := &levelHandler{level: level, next: l.handler}
+	return slog.New(h).With("rule", rule.Name)
+}
+
+// Ring 返回供界面查看的最近日志
+func (l *Logger) Ring() *Ring {
+	return l.ring
+}
+
+// Close 关闭日志文件
+func (l *Logger) Close() error {
+	if l.file != nil {
+		return l.file.Close()
+	}
+	return nil
+}
+
+// levelHandler 按级别过滤后交给下一个 handler
+type levelHandler struct {
+	level slog.Level
+	next  slog.Handler
+}
+
+func (h *levelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level && h.next.Enabled(ctx, level)
+}
+
+func (h *levelHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.next.Handle(ctx, r)
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{level: h.level, next: h.next.WithAttrs(attrs)}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{level: h.level, next: h.next.WithGroup(name)}
+}
+
+// fanout 将记录分发给多个 handler
+type fanout []slog.Handler
+
+func (f fanout) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range f {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f fanout) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range f {
+		if h.Enabled(ctx, r.Level) {
+			errs = append(errs, h.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (f fanout) WithAttrs(attrs []slog.Attr) slog.Handler {
+	hs := make(fanout, len(f))
+	for i, h := range f {
+		hs[i] = h.WithAttrs(attrs)
+	}
+	return hs
+}
+
+func (f fanout) WithGroup(name string) slog.Handler {
+	hs := make(fanout, len(f))
+	for i, h := range f {
+		hs[i] = h.WithGroup(name)
+	}
+	return hs
+}
+
+// Discard 返回丢弃所有日志的 slog.Logger，日志调用几乎没有开销
+func Discard() *slog.Logger {
+	return slog.New(discard{})
+}
+
+type discard struct{}
+
+func (discard) Enabled(context.Context, slog.Level) bool  { return false }
+func (discard) Handle(context.Context, slog.Record) error { return nil }
+func (d discard) WithAttrs([]slog.Attr) slog.Handler      { return d }
+func (d discard) WithGroup(string) slog.Handler           { return d }
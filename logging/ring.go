@@ -0,0 +1,138 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry 一条已格式化的日志
+type Entry struct {
+	Time    time.Time
+	Level   slog.Level
+	Rule    string // 规则日志的 rule 属性
+	Message string
+	Attrs   string // key=value 形式的属性
+}
+
+// Ring 保留最近若干条日志，供界面查看
+type Ring struct {
+	mu      sync.Mutex
+	entries []Entry
+	next    int
+	count   int
+}
+
+func NewRing(size int) *Ring {
+	return &Ring{entries: make([]Entry, size)}
+}
+
+func (r *Ring) add(e Entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.entries[r.next] = e
+	r.next = (r.next + 1) % len(r.entries)
+	if r.count < len(r.entries) {
+		r.count++
+	}
+}
+
+// Entries 返回最近的日志，按时间从旧到新排列
+func (r *Ring) Entries() []Entry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	size := len(r.entries)
+	out := make([]Entry, r.count)
+	start := (r.next - r.count + size) % size
+	for i := range out {
+		out[i] = r.entries[(start+i)%size]
+	}
+	return out
+}
+
+// Clear 清空缓冲区
+func (r *Ring) Clear() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.next, r.count = 0, 0
+}
+
+// ringHandler 将记录格式化后写入 Ring
+type ringHandler struct {
+	ring   *Ring
+	rule   string
+	prefix string // WithGroup 累积的键前缀
+	attrs  string // WithAttrs 累积的属性
+}
+
+func (h *ringHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (h *ringHandler) Handle(_ context.Context, rec slog.Record) error {
+	var b strings.Builder
+	b.WriteString(h.attrs)
+	rec.Attrs(func(a slog.Attr) bool {
+		writeAttr(&b, h.prefix, a)
+		return true
+	})
+
+	h.ring.add(Entry{
+		Time:    rec.Time,
+		Level:   rec.Level,
+		Rule:    h.rule,
+		Message: rec.Message,
+		Attrs:   strings.TrimSpace(b.String()),
+	})
+	return nil
+}
+
+func (h *ringHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	var b strings.Builder
+	b.WriteString(h.attrs)
+	for _, a := range attrs {
+		// 规则名单独保存，便于界面按规则过滤
+		if h.prefix == "" && a.Key == "rule" {
+			c.rule = a.Value.String()
+			continue
+		}
+		writeAttr(&b, h.prefix, a)
+	}
+	c.attrs = b.String()
+	return &c
+}
+
+func (h *ringHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	c := *h
+	c.prefix = h.prefix + name + "."
+	return &c
+}
+
+func writeAttr(b *strings.Builder, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		for _, g := range a.Value.Group() {
+			writeAttr(b, prefix+a.Key+".", g)
+		}
+		return
+	}
+
+	v := a.Value.String()
+	if v == "" || strings.ContainsAny(v, " \"=") {
+		v = fmt.Sprintf("%q", v)
+	}
+	fmt.Fprintf(b, " %s%s=%s", prefix, a.Key, v)
+}
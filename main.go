@@ -3,13 +3,21 @@ package main
 import (
 	"flag"
 	"fmt"
+	"gopf/audit"
 	"gopf/config"
+	"gopf/control"
 	"gopf/forwarder"
+	"gopf/hooks"
+	"gopf/logging"
+	"gopf/metrics"
+	"gopf/stats"
 	"gopf/ui"
 	"log"
+	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/charmbracelet/lipgloss"
 )
@@ -17,6 +25,9 @@ import (
 const (
 	version           = "v0.1.4"
 	defaultConfigFile = "gopf.yaml"
+
+	// 退出时等待已排队的钩子执行完的最长时间
+	hookDrainTimeout = 5 * time.Second
 )
 
 // UI样式定义
@@ -61,38 +72,167 @@ func loadConfig(filename string) (*config.Config, error) {
 	return cfg, nil
 }
 
-// 启动所有转发规则
-func startForwarders(cfg *config.Config) map[string]*forwarder.Forwarder {
-	forwarders := make(map[string]*forwarder.Forwarder)
-	for i := range cfg.Rules {
-		rule := &cfg.Rules[i]
-		f := forwarder.NewForwarder(rule)
-		if err := f.Start(); err != nil {
-			rule.Error = err.Error()
-			log.Printf("警告: 端口转发启动失败 [%s]: %v\n", rule.Name, err)
+// 启动配置中未停用的规则，返回管理所有规则的 Manager。
+// 累计统计从 Manager 读取各规则的会话计数。
+func startForwarders(cfg *config.Config, env *forwarder.Env) *forwarder.Manager {
+	mgr := forwarder.NewManager(env)
+	for _, rule := range cfg.Rules {
+		if err := mgr.Put(rule); err != nil {
+			log.Printf("警告: %v\n", err)
 			continue
 		}
-		rule.IsRunning = true
-		forwarders[rule.Name] = f
+		if rule.Disabled {
+			continue
+		}
+		if err := mgr.Start(rule.ID); err != nil {
+			log.Printf("警告: 端口转发启动失败 [%s]: %v\n", rule.Name, err)
+		}
+	}
+	if env.Stats != nil {
+		go env.Stats.Run(mgr.RecordStats, cfg.Stats.FlushInterval, nil)
+	}
+	return mgr
+}
+
+// 打开累计统计文件
+func openStats(cfg *config.Config) (*stats.Store, error) {
+	file := cfg.Stats.File
+	if file == "" {
+		file = stats.DefaultFile
+	}
+
+	store, err := stats.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	migrateStats(store, cfg)
+	return store, nil
+}
+
+// migrateStats 将旧版本按规则名称保存的累计统计转到规则 ID 下
+func migrateStats(store *stats.Store, cfg *config.Config) {
+	names := make(map[string]string, len(cfg.Rules))
+	for i := range cfg.Rules {
+		names[cfg.Rules[i].Name] = cfg.Rules[i].ID
+	}
+	store.MigrateNames(names)
+}
+
+// 退出前写入最后一次累计统计
+func flushStats(store *stats.Store, mgr *forwarder.Manager) {
+	if store == nil {
+		return
+	}
+	mgr.RecordStats()
+	if err := store.Save(); err != nil {
+		log.Printf("警告: 保存统计数据失败: %v\n", err)
+	}
+}
+
+// 启动指标接口，失败时只打印警告
+func startMetrics(cfg *config.Config, mgr *forwarder.Manager) {
+	if cfg.Metrics == nil {
+		return
+	}
+	if _, err := metrics.Serve(cfg.Metrics, mgr.Snapshot); err != nil {
+		log.Printf("警告: %v\n", err)
+	}
+}
+
+// 打开控制接口，失败时只打印警告。没有 control 配置时也监听默认套接字，
+// 使 gopf add/rm/start/stop 能找到运行中的实例，而不是直接修改它的配置文件
+func startControl(cfg *config.Config) *control.Server {
+	ctlCfg := cfg.Control
+	if ctlCfg == nil {
+		ctlCfg = &config.ControlConfig{}
+	}
+	ctl, err := control.Listen(ctlCfg)
+	if err != nil {
+		log.Printf("警告: %v\n", err)
+		return nil
+	}
+	return ctl
+}
+
+// 打开累计统计、审计日志和事件钩子等转发器共用的依赖
+func openEnv(cfg *config.Config, logs *logging.Logger) (*forwarder.Env, error) {
+	env := &forwarder.Env{Logs: logs}
+
+	var err error
+	if cfg.Stats != nil {
+		if env.Stats, err = openStats(cfg); err != nil {
+			log.Printf("警告: 加载统计数据失败: %v\n", err)
+		}
+	}
+
+	if cfg.Audit != nil {
+		if env.Audit, err = audit.Open(cfg.Audit); err != nil {
+			return nil, err
+		}
+	}
+
+	if env.Hooks, err = hooks.New(cfg.Hooks, logs.Slog()); err != nil {
+		if env.Audit != nil {
+			env.Audit.Close()
+		}
+		return nil, err
+	}
+	return env, nil
+}
+
+// 退出前写入累计统计，等待钩子执行完并关闭审计日志
+func closeEnv(env *forwarder.Env, mgr *forwarder.Manager) {
+	flushStats(env.Stats, mgr)
+	env.Hooks.Close(hookDrainTimeout)
+	if env.Audit != nil {
+		env.Audit.Close()
 	}
-	return forwarders
 }
 
 // 设置信号处理
-func setupSignalHandler(forwarders map[string]*forwarder.Forwarder) {
+func setupSignalHandler(mgr *forwarder.Manager, env *forwarder.Env) {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
 	go func() {
 		<-sigChan
 		fmt.Println("\n正在关闭所有端口转发...")
-		for _, f := range forwarders {
-			f.Stop()
-		}
+		mgr.StopAll()
+		closeEnv(env, mgr)
 		os.Exit(0)
 	}()
 }
 
+// watchConfig 在配置文件变化或收到 SIGHUP 时发出通知，直到 done 关闭
+func watchConfig(cfg *config.Config, done <-chan struct{}) <-chan struct{} {
+	reload := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case reload <- struct{}{}:
+		default: // 已有未处理的通知
+		}
+	}
+
+	if interval := cfg.WatchInterval(); interval > 0 {
+		go config.Watch(cfg.Path(), interval, done, notify)
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-hup:
+				notify()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return reload
+}
+
 // 显示版本信息
 func printVersion() {
 	title := titleStyle.Render("GOPF")
@@ -101,24 +241,100 @@ func printVersion() {
 }
 
 func main() {
+	// 子命令
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "replay":
+			if err := runReplay(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "audit":
+			if err := runAudit(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "serve":
+			if err := runServe(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "ui":
+			if err := runUI(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "list", "ls", "add", "rm", "start", "stop", "stats":
+			if err := runCommand(os.Args[1], os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, "错误:", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	// 解析命令行参数
 	configFile := flag.String("config", defaultConfigFile, "配置文件路径")
+	headless := flag.Bool("headless", false, "不启动界面，等同于 gopf serve")
+	failOn := flag.String("fail", failAny, "无界面模式下规则启动失败时以非零状态退出: any、all 或 none")
 	flag.Parse()
 
+	if *headless {
+		if err := serve(*configFile, *failOn); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if err := runTUI(*configFile); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// runTUI 启动配置中的规则并运行界面，退出界面时停止所有规则
+func runTUI(configFile string) error {
 	// 加载配置
-	cfg, err := loadConfig(*configFile)
+	cfg, err := loadConfig(configFile)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+
+	// 初始化日志，界面运行期间不输出到终端
+	logs, err := logging.New(cfg.Log, nil)
+	if err != nil {
+		log.Printf("警告: %v，日志仅在界面中查看\n", err)
+		logs, _ = logging.New(nil, nil)
+	}
+	defer logs.Close()
+
+	env, err := openEnv(cfg, logs)
+	if err != nil {
+		return err
 	}
 
 	// 启动转发器
-	forwarders := startForwarders(cfg)
+	mgr := startForwarders(cfg, env)
+	startMetrics(cfg, mgr)
+	ctl := startControl(cfg)
+	defer ctl.Close()
 
 	// 设置信号处理
-	setupSignalHandler(forwarders)
+	setupSignalHandler(mgr, env)
+	done := make(chan struct{})
+	defer close(done)
+	reload := watchConfig(cfg, done)
+
+	// 界面运行期间，标准库 log 的输出也进入日志，退出后恢复输出到终端
+	out, flags := log.Writer(), log.Flags()
+	slog.SetDefault(logs.Slog())
 
 	// 启动UI
-	if err := ui.StartUI(cfg, forwarders, version); err != nil {
-		log.Fatalf("UI启动失败: %v", err)
+	err = ui.StartUI(cfg, mgr, env, version, ctl, reload)
+	log.SetOutput(out)
+	log.SetFlags(flags)
+	closeEnv(env, mgr)
+	if err != nil {
+		return fmt.Errorf("UI启动失败: %v", err)
 	}
+	return nil
 }
@@ -0,0 +1,205 @@
+// Package metrics 以 Prometheus 文本格式输出各转发规则的统计数据。
+//
+// 数据来自 forwarder.Manager 的规则快照，转发器每秒同步一次计数器和延迟分位数，
+// 每条指标都带有 rule、type、local_port 和 remote 标签。
+package metrics
+
+import (
+	"fmt"
+	"gopf/config"
+	"gopf/forwarder"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultListen = "127.0.0.1:9469"
+	DefaultPath   = "/metrics"
+)
+
+// RulesFunc 返回当前各规则的配置和运行状态
+type RulesFunc func() []forwarder.RuleStatus
+
+// Serve 按配置启动指标监听，监听失败时直接返回错误
+func Serve(cfg *config.MetricsConfig, rules RulesFunc) (*http.Server, error) {
+	addr, path := cfg.Listen, cfg.Path
+	if addr == "" {
+		addr = DefaultListen
+	}
+	if path == "" {
+		path = DefaultPath
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("指标接口监听失败: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(path, Handler(rules))
+	srv := &http.Server{Handler: mux}
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Printf("指标接口异常退出: %v", err)
+		}
+	}()
+	return srv, nil
+}
+
+// Handler 返回输出指标的 http.Handler
+func Handler(rules RulesFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Write(w, rules())
+	})
+}
+
+type metric struct {
+	name  string
+	kind  string // counter 或 gauge
+	help  string
+	label string // 额外标签名，为空表示无
+	value func(r *forwarder.RuleStatus) []sample
+}
+
+type sample struct {
+	label string
+	value uint64
+}
+
+func one(v uint64) []sample {
+	return []sample{{value: v}}
+}
+
+func boolValue(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+var metricsList = []metric{
+	{"gopf_rule_up", "gauge", "Whether the rule is running (1) or stopped (0).", "",
+		func(r *forwarder.RuleStatus) []sample { return one(boolValue(r.Running)) }},
+	{"gopf_rule_healthy", "gauge", "Whether the rule is running without errors and its last upstream dial succeeded.", "",
+		func(r *forwarder.RuleStatus) []sample {
+			return one(boolValue(r.Running && r.Error == "" && !r.UpstreamDown))
+		}},
+	{"gopf_quota_exceeded", "gauge", "Whether the rule has used up its quota for the current period.", "",
+		func(r *forwarder.RuleStatus) []sample { return one(boolValue(r.OverQuota)) }},
+	{"gopf_bytes_total", "counter", "Payload bytes forwarded, by direction.", "direction",
+		func(r *forwarder.RuleStatus) []sample {
+			return []sample{
+				{"sent", r.BytesSent},
+				{"recv", r.BytesRecv},
+			}
+		}},
+	{"gopf_wire_bytes_total", "counter", "Bytes on the compressed link, by direction.", "direction",
+		func(r *forwarder.RuleStatus) []sample {
+			return []sample{
+				{"sent", r.WireSent},
+				{"recv", r.WireRecv},
+			}
+		}},
+	{"gopf_chunks_total", "counter", "Chunks forwarded, by direction.", "direction",
+		func(r *forwarder.RuleStatus) []sample {
+			return []sample{
+				{"sent", r.ChunksSent},
+				{"recv", r.ChunksRecv},
+			}
+		}},
+	{"gopf_connections_active", "gauge", "Connections currently being forwarded.", "",
+		func(r *forwarder.RuleStatus) []sample { return one(r.Connections) }},
+	{"gopf_connections_total", "counter", "Connections forwarded since the rule started.", "",
+		func(r *forwarder.RuleStatus) []sample { return one(r.TotalConns) }},
+	{"gopf_dial_failures_total", "counter", "Failed dials to the upstream.", "",
+		func(r *forwarder.RuleStatus) []sample { return one(r.DialFailures) }},
+	{"gopf_mirror_drops_total", "counter", "Chunks dropped because the mirror was too slow.", "",
+		func(r *forwarder.RuleStatus) []sample { return one(r.MirrorDrops) }},
+	{"gopf_mirror_errors_total", "counter", "Mirror dial and write errors.", "",
+		func(r *forwarder.RuleStatus) []sample { return one(r.MirrorErrors) }},
+	{"gopf_faults_total", "counter", "Injected faults, by kind.", "kind",
+		func(r *forwarder.RuleStatus) []sample {
+			return []sample{
+				{"delay", r.FaultDelays},
+				{"reset", r.FaultResets},
+				{"corrupt", r.FaultCorrupt},
+				{"truncate", r.FaultTrunc},
+			}
+		}},
+}
+
+type summary struct {
+	name  string
+	help  string
+	value func(r *forwarder.RuleStatus) forwarder.Latency
+}
+
+var summaries = []summary{
+	{"gopf_dial_duration_seconds", "Time to establish the upstream connection.",
+		func(r *forwarder.RuleStatus) forwarder.Latency { return r.DialLatency }},
+	{"gopf_tls_handshake_seconds", "TLS handshake time observed passively on forwarded connections.",
+		func(r *forwarder.RuleStatus) forwarder.Latency { return r.TLSLatency }},
+	{"gopf_ttfb_seconds", "Time from the first client byte to the first upstream byte.",
+		func(r *forwarder.RuleStatus) forwarder.Latency { return r.TTFB }},
+}
+
+// Write 将规则统计写为 Prometheus 文本格式
+func Write(w io.Writer, rules []forwarder.RuleStatus) {
+	labels := make([]string, len(rules))
+	for i := range rules {
+		labels[i] = ruleLabels(&rules[i])
+	}
+
+	for _, m := range metricsList {
+		fmt.Fprintf(w, "# HELP %s %s\n", m.name, m.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.kind)
+		for i := range rules {
+			for _, s := range m.value(&rules[i]) {
+				l := labels[i]
+				if m.label != "" {
+					l += fmt.Sprintf(",%s=%q", m.label, s.label)
+				}
+				fmt.Fprintf(w, "%s{%s} %d\n", m.name, l, s.value)
+			}
+		}
+	}
+
+	for _, m := range summaries {
+		fmt.Fprintf(w, "# HELP %s %s\n", m.name, m.help)
+		fmt.Fprintf(w, "# TYPE %s summary\n", m.name)
+		for i := range rules {
+			lat := m.value(&rules[i])
+			for _, q := range []struct {
+				quantile string
+				value    float64
+			}{
+				{"0.5", lat.P50.Seconds()},
+				{"0.95", lat.P95.Seconds()},
+				{"0.99", lat.P99.Seconds()},
+			} {
+				fmt.Fprintf(w, "%s{%s,quantile=%q} %g\n", m.name, labels[i], q.quantile, q.value)
+			}
+			fmt.Fprintf(w, "%s_sum{%s} %g\n", m.name, labels[i], lat.Sum.Seconds())
+			fmt.Fprintf(w, "%s_count{%s} %d\n", m.name, labels[i], lat.Count)
+		}
+	}
+}
+
+func ruleLabels(r *forwarder.RuleStatus) string {
+	remote := ""
+	if r.RemoteHost != "" || r.RemotePort != 0 {
+		remote = net.JoinHostPort(r.RemoteHost, strconv.Itoa(r.RemotePort))
+	}
+	return fmt.Sprintf(`rule="%s",type="%s",local_port="%d",remote="%s"`,
+		escape(r.Name), r.Kind(), r.LocalPort, escape(remote))
+}
+
+// escape 按文本格式要求转义标签值
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
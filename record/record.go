@@ -0,0 +1,213 @@
+// Package record 实现转发会话的录制文件格式。
+//
+// 每个连接对应一个 .gopfrec 文件，格式如下：
+//
+//	文件头：8 字节魔数 "GOPFREC1"，随后是一行以 \n 结尾的 JSON 元数据（见 Meta）
+//	数据块：重复出现直到文件结束
+//	  1 字节方向：'>' 客户端发往上游，'<' 上游发往客户端
+//	  8 字节时间偏移：距录制开始的纳秒数，大端 int64
+//	  4 字节长度：大端 uint32
+//	  N 字节负载
+//
+// 数据块按实际转发的先后顺序写入。
+package record
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"gopf/internal/filename"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	magic     = "GOPFREC1"
+	Extension = ".gopfrec"
+)
+
+// Direction 数据块的方向
+type Direction byte
+
+const (
+	ClientToUpstream Direction = '>'
+	UpstreamToClient Direction = '<'
+)
+
+// Meta 录制文件的元数据
+type Meta struct {
+	Rule     string    `json:"rule"`
+	Client   string    `json:"client"`
+	Upstream string    `json:"upstream"`
+	Start    time.Time `json:"start"`
+}
+
+// Chunk 一个录制的数据块
+type Chunk struct {
+	Dir    Direction
+	Offset time.Duration
+	Data   []byte
+}
+
+// Writer 将一个连接的数据块写入录制文件，可被两个方向的转发协程并发调用
+type Writer struct {
+	mu     sync.Mutex
+	file   *os.File
+	buf    *bufio.Writer
+	start  time.Time
+	closed bool
+}
+
+// Create 在目录 dir 下创建新的录制文件
+func Create(dir string, meta Meta) (*Writer, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	name := filename.New(meta.Rule, meta.Start, Extension)
+	file, err := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := json.Marshal(meta)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	w := &Writer{file: file, buf: bufio.NewWriter(file), start: meta.Start}
+	w.buf.WriteString(magic)
+	w.buf.Write(header)
+	w.buf.WriteByte('\n')
+	return w, nil
+}
+
+// Write 追加一个数据块
+func (w *Writer) Write(dir Direction, p []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return os.ErrClosed
+	}
+
+	var hdr [13]byte
+	hdr[0] = byte(dir)
+	binary.BigEndian.PutUint64(hdr[1:9], uint64(time.Since(w.start)))
+	binary.BigEndian.PutUint32(hdr[9:13], uint32(len(p)))
+	if _, err := w.buf.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := w.buf.Write(p)
+	return err
+}
+
+// Close 刷新缓冲并关闭文件，可重复调用
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	if err := w.buf.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// Reader 顺序读取录制文件
+type Reader struct {
+	file *os.File
+	buf  *bufio.Reader
+	meta Meta
+}
+
+// Open 打开录制文件并读取元数据
+func Open(path string) (*Reader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Reader{file: file, buf: bufio.NewReader(file)}
+	head := make([]byte, len(magic))
+	if _, err := io.ReadFull(r.buf, head); err != nil || string(head) != magic {
+		file.Close()
+		return nil, fmt.Errorf("%s 不是有效的录制文件", path)
+	}
+
+	line, err := r.buf.ReadBytes('\n')
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("读取录制元数据失败: %v", err)
+	}
+	if err := json.Unmarshal(line, &r.meta); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("解析录制元数据失败: %v", err)
+	}
+	return r, nil
+}
+
+// Meta 返回录制文件的元数据
+func (r *Reader) Meta() Meta {
+	return r.meta
+}
+
+// Next 返回下一个数据块，文件结束时返回 io.EOF
+func (r *Reader) Next() (Chunk, error) {
+	var hdr [13]byte
+	if _, err := io.ReadFull(r.buf, hdr[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return Chunk{}, fmt.Errorf("录制文件被截断")
+		}
+		return Chunk{}, err
+	}
+
+	chunk := Chunk{
+		Dir:    Direction(hdr[0]),
+		Offset: time.Duration(binary.BigEndian.Uint64(hdr[1:9])),
+		Data:   make([]byte, binary.BigEndian.Uint32(hdr[9:13])),
+	}
+	if chunk.Dir != ClientToUpstream && chunk.Dir != UpstreamToClient {
+		return Chunk{}, fmt.Errorf("未知的数据块方向: %q", chunk.Dir)
+	}
+	if _, err := io.ReadFull(r.buf, chunk.Data); err != nil {
+		return Chunk{}, fmt.Errorf("录制文件被截断")
+	}
+	return chunk, nil
+}
+
+// Close 关闭录制文件
+func (r *Reader) Close() error {
+	return r.file.Close()
+}
+
+// ReadAll 读取录制文件的全部数据块
+func ReadAll(path string) (Meta, []Chunk, error) {
+	r, err := Open(path)
+	if err != nil {
+		return Meta{}, nil, err
+	}
+	defer r.Close()
+
+	var chunks []Chunk
+	for {
+		chunk, err := r.Next()
+		if err == io.EOF {
+			return r.Meta(), chunks, nil
+		}
+		if err != nil {
+			return r.Meta(), chunks, err
+		}
+		chunks = append(chunks, chunk)
+	}
+}
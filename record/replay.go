@@ -0,0 +1,109 @@
+package record
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Options 回放选项
+type Options struct {
+	Timing  bool          // 按录制时的时间间隔发送数据，否则尽快发送
+	Timeout time.Duration // 等待对端数据的超时时间
+}
+
+// Result 一次回放的结果
+type Result struct {
+	BytesSent  uint64
+	BytesRecv  uint64
+	Mismatches int // 对端数据与录制内容不一致的数据块数
+}
+
+// ReplayClient 连接 target 重放录制中的客户端流量，并将服务端响应与录制内容比对
+func ReplayClient(path, target string, opts Options) (Result, error) {
+	_, chunks, err := ReadAll(path)
+	if err != nil {
+		return Result{}, err
+	}
+
+	conn, err := net.DialTimeout("tcp", target, opts.timeout())
+	if err != nil {
+		return Result{}, err
+	}
+	defer conn.Close()
+
+	return play(conn, chunks, ClientToUpstream, opts)
+}
+
+// ServeUpstream 在 listen 上充当伪造的上游，对每个接入的连接重放录制中的上游响应
+func ServeUpstream(path, listen string, opts Options, logf func(format string, args ...any)) error {
+	_, chunks, err := ReadAll(path)
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			defer conn.Close()
+			res, err := play(conn, chunks, UpstreamToClient, opts)
+			if err != nil {
+				logf("%s: 回放中断: %v", conn.RemoteAddr(), err)
+			}
+			logf("%s: 发送 %d 字节，接收 %d 字节，不一致的数据块 %d 个",
+				conn.RemoteAddr(), res.BytesSent, res.BytesRecv, res.Mismatches)
+		}()
+	}
+}
+
+// play 依次处理数据块：方向为 send 的写入连接，另一方向的从连接读取相同长度并比对
+func play(conn net.Conn, chunks []Chunk, send Direction, opts Options) (Result, error) {
+	var res Result
+	start := time.Now()
+
+	for _, chunk := range chunks {
+		if chunk.Dir == send {
+			if opts.Timing {
+				if wait := time.Until(start.Add(chunk.Offset)); wait > 0 {
+					time.Sleep(wait)
+				}
+			}
+			if _, err := conn.Write(chunk.Data); err != nil {
+				return res, err
+			}
+			res.BytesSent += uint64(len(chunk.Data))
+			continue
+		}
+
+		buf := make([]byte, len(chunk.Data))
+		conn.SetReadDeadline(time.Now().Add(opts.timeout()))
+		n, err := io.ReadFull(conn, buf)
+		res.BytesRecv += uint64(n)
+		if err != nil {
+			return res, fmt.Errorf("等待对端数据失败: %v", err)
+		}
+		if !bytes.Equal(buf, chunk.Data) {
+			res.Mismatches++
+		}
+	}
+	return res, nil
+}
+
+func (o Options) timeout() time.Duration {
+	if o.Timeout <= 0 {
+		return 10 * time.Second
+	}
+	return o.Timeout
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"gopf/record"
+	"log"
+	"time"
+)
+
+// runReplay 处理 replay 子命令：充当伪造上游，或向服务端重放客户端流量
+func runReplay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	file := fs.String("file", "", "录制文件路径")
+	listen := fs.String("listen", "", "充当伪造上游的监听地址，例如 :9000")
+	target := fs.String("target", "", "重放客户端流量的目标地址，例如 127.0.0.1:80")
+	timing := fs.Bool("timing", false, "按录制时的时间间隔发送数据")
+	timeout := fs.Duration("timeout", 10*time.Second, "等待对端数据的超时时间")
+	fs.Parse(args)
+
+	if *file == "" || (*listen == "") == (*target == "") {
+		fs.Usage()
+		return fmt.Errorf("需要指定 -file，以及 -listen 或 -target 之一")
+	}
+
+	opts := record.Options{Timing: *timing, Timeout: *timeout}
+	if *listen != "" {
+		log.Printf("在 %s 上回放 %s 的上游响应", *listen, *file)
+		return record.ServeUpstream(*file, *listen, opts, log.Printf)
+	}
+
+	res, err := record.ReplayClient(*file, *target, opts)
+	fmt.Printf("发送 %d 字节，接收 %d 字节，不一致的数据块 %d 个\n", res.BytesSent, res.BytesRecv, res.Mismatches)
+	return err
+}
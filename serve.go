@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"gopf/config"
+	"gopf/control"
+	"gopf/forwarder"
+	"gopf/logging"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// 规则启动失败时的退出策略
+const (
+	failAny  = "any"  // 任一规则启动失败即退出
+	failAll  = "all"  // 所有规则都启动失败时才退出
+	failNone = "none" // 始终继续运行
+)
+
+// runServe 处理 serve 子命令：不启动界面，在前台运行配置中未停用的规则
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	configFile := fs.String("config", defaultConfigFile, "配置文件路径")
+	failOn := fs.String("fail", failAny, "规则启动失败时以非零状态退出: any、all 或 none")
+	fs.Parse(args)
+
+	return serve(*configFile, *failOn)
+}
+
+// serve 以无界面模式运行，日志输出到标准输出。
+// 收到 SIGINT/SIGTERM 时停止所有规则后退出，收到 SIGHUP 时重新加载配置中的规则。
+func serve(configFile, failOn string) error {
+	if failOn != failAny && failOn != failAll && failOn != failNone {
+		return fmt.Errorf("-fail 只能是 %s、%s 或 %s", failAny, failAll, failNone)
+	}
+
+	cfg, err := config.LoadConfig(configFile)
+	if err != nil {
+		return fmt.Errorf("加载配置文件失败: %v", err)
+	}
+
+	logs, err := logging.New(cfg.Log, os.Stdout)
+	if err != nil {
+		return err
+	}
+	defer logs.Close()
+	// 运行期间标准库 log 的输出也进入结构化日志，返回后恢复，便于输出退出原因
+	out, flags := log.Writer(), log.Flags()
+	slog.SetDefault(logs.Slog())
+	defer func() {
+		log.SetOutput(out)
+		log.SetFlags(flags)
+	}()
+
+	env, err := openEnv(cfg, logs)
+	if err != nil {
+		return err
+	}
+
+	d := &daemon{configFile: configFile, cfg: cfg, env: env, mgr: startForwarders(cfg, env)}
+	defer closeEnv(env, d.mgr)
+	startMetrics(cfg, d.mgr)
+	if err := checkStartup(cfg, d.mgr, failOn); err != nil {
+		d.stopAll()
+		return err
+	}
+
+	ctl := startControl(cfg)
+	defer ctl.Close()
+	ctl.Serve(d, env.Hooks, control.Info{Version: version, Mode: "serve"})
+	slog.Info("gopf 已启动", "event", "serve_started", "version", version, "rules", len(cfg.Rules), "running", d.mgr.Running())
+
+	// 配置文件变化时自动重新加载，与 SIGHUP 效果相同
+	stopWatch := make(chan struct{})
+	defer close(stopWatch)
+	if interval := cfg.WatchInterval(); interval > 0 {
+		go config.Watch(configFile, interval, stopWatch, d.reload)
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range sigChan {
+		if sig != syscall.SIGHUP {
+			slog.Info("正在关闭所有端口转发", "event", "serve_stopping", "signal", sig.String())
+			break
+		}
+		d.reload()
+	}
+	signal.Stop(sigChan)
+
+	d.stopAll()
+	return nil
+}
+
+// checkStartup 按退出策略判断启动失败的规则是否应导致退出
+func checkStartup(cfg *config.Config, mgr *forwarder.Manager, failOn string) error {
+	enabled := 0
+	for i := range cfg.Rules {
+		if !cfg.Rules[i].Disabled {
+			enabled++
+		}
+	}
+	running := mgr.Running()
+	failed := enabled - running
+	switch {
+	case failed == 0 || failOn == failNone:
+		return nil
+	case failOn == failAll && running > 0:
+		return nil
+	}
+	return fmt.Errorf("%d 条规则启动失败", failed)
+}
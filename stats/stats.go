@@ -0,0 +1,315 @@
+// Package stats 将各规则的累计流量保存到独立的状态文件中，使统计数据在重启后依然保留。
+//
+// 转发器的会话计数器在每次启动和清空统计时归零，Store 定期读取这些计数器，
+// 将与上次读取之间的增量累加到总计以及按日、按月的分桶中。转发器停止或清空统计前，
+// 由调用方通过 Flush 计入最后的计数，之后的计数从零开始。
+// 统计按规则 ID 保存，规则改名后沿用原来的统计，删除后新建的同名规则从零开始。
+package stats
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultFile          = "gopf-stats.json"
+	DefaultFlushInterval = time.Minute
+
+	dayLayout   = "2006-01-02"
+	monthLayout = "2006-01"
+	keepDays    = 92 // 按日分桶保留的天数
+)
+
+// Counters 一组累计计数
+type Counters struct {
+	BytesSent   uint64 `json:"bytes_sent"`
+	BytesRecv   uint64 `json:"bytes_recv"`
+	Connections uint64 `json:"connections"`
+}
+
+// Sample 一条规则当前会话的计数
+type Sample struct {
+	ID string // 规则 ID
+	Counters
+}
+
+func (c *Counters) add(d Counters) {
+	c.BytesSent += d.BytesSent
+	c.BytesRecv += d.BytesRecv
+	c.Connections += d.Connections
+}
+
+// RuleStats 一条规则自 ResetAt 起的累计统计
+type RuleStats struct {
+	Total   Counters            `json:"total"`
+	Daily   map[string]Counters `json:"daily,omitempty"`   // 键为 2006-01-02
+	Monthly map[string]Counters `json:"monthly,omitempty"` // 键为 2006-01
+	ResetAt time.Time           `json:"reset_at"`
+}
+
+// Day 返回 t 所在日的统计
+func (r RuleStats) Day(t time.Time) Counters {
+	return r.Daily[t.Format(dayLayout)]
+}
+
+// Month 返回 t 所在月的统计
+func (r RuleStats) Month(t time.Time) Counters {
+	return r.Monthly[t.Format(monthLayout)]
+}
+
+type stateFile struct {
+	Rules map[string]*RuleStats `json:"rules"`
+}
+
+// Store 累计统计的存储，可并发使用
+type Store struct {
+	mu    sync.Mutex
+	path  string
+	rules map[string]*RuleStats
+	seen  map[string]Counters // 上次读取到的会话计数
+	dirty bool
+}
+
+// Open 加载状态文件，文件不存在时返回空的存储
+func Open(path string) (*Store, error) {
+	s := &Store{
+		path:  path,
+		rules: make(map[string]*RuleStats),
+		seen:  make(map[string]Counters),
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var state stateFile
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("解析统计文件失败: %v", err)
+	}
+	for id, r := range state.Rules {
+		if r != nil {
+			s.rules[id] = r
+		}
+	}
+	return s, nil
+}
+
+// Update 读取各规则的会话计数，将增量计入累计统计
+func (s *Store) Update(samples []Sample) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, sample := range samples {
+		s.update(sample, now)
+		s.seen[sample.ID] = sample.Counters
+	}
+}
+
+// Flush 计入规则会话结束（停止或清空统计）时的最后计数，之后该规则的会话计数从零开始
+func (s *Store) Flush(sample Sample) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.update(sample, time.Now())
+	delete(s.seen, sample.ID)
+}
+
+func (s *Store) update(sample Sample, now time.Time) {
+	cur, prev := sample.Counters, s.seen[sample.ID]
+	d := Counters{
+		BytesSent:   delta(prev.BytesSent, cur.BytesSent),
+		BytesRecv:   delta(prev.BytesRecv, cur.BytesRecv),
+		Connections: delta(prev.Connections, cur.Connections),
+	}
+	if d != (Counters{}) {
+		s.add(sample.ID, d, now)
+	}
+}
+
+// delta 返回计数的增量。会话计数只在 Flush 之后归零，这里不会出现变小的情况，仅作防御
+func delta(prev, cur uint64) uint64 {
+	if cur < prev {
+		return 0
+	}
+	return cur - prev
+}
+
+func (s *Store) add(id string, d Counters, now time.Time) {
+	r := s.rule(id, now)
+	r.Total.add(d)
+
+	day, month := now.Format(dayLayout), now.Format(monthLayout)
+	c := r.Daily[day]
+	c.add(d)
+	r.Daily[day] = c
+	c = r.Monthly[month]
+	c.add(d)
+	r.Monthly[month] = c
+
+	s.dirty = true
+}
+
+func (s *Store) rule(id string, now time.Time) *RuleStats {
+	r, ok := s.rules[id]
+	if !ok {
+		r = &RuleStats{ResetAt: now}
+		s.rules[id] = r
+	}
+	if r.Daily == nil {
+		r.Daily = make(map[string]Counters)
+	}
+	if r.Monthly == nil {
+		r.Monthly = make(map[string]Counters)
+	}
+	return r
+}
+
+// Get 返回规则 id 的累计统计副本，没有记录时返回零值
+func (s *Store) Get(id string) RuleStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.rules[id]
+	if !ok {
+		return RuleStats{}
+	}
+	c := *r
+	c.Daily = make(map[string]Counters, len(r.Daily))
+	for k, v := range r.Daily {
+		c.Daily[k] = v
+	}
+	c.Monthly = make(map[string]Counters, len(r.Monthly))
+	for k, v := range r.Monthly {
+		c.Monthly[k] = v
+	}
+	return c
+}
+
+// Reset 清空规则 id 的累计统计，并将 ResetAt 设为当前时间
+func (s *Store) Reset(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rules[id] = &RuleStats{ResetAt: time.Now()}
+	s.dirty = true
+}
+
+// Remove 删除规则 id 的累计统计，用于删除规则时
+func (s *Store) Remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.rules[id]; ok {
+		delete(s.rules, id)
+		s.dirty = true
+	}
+	delete(s.seen, id)
+}
+
+// MigrateNames 将旧版本按规则名称保存的统计转到规则 ID 下，names 为名称到 ID 的对应。
+// 已有 ID 下统计的规则保持不变。
+func (s *Store) MigrateNames(names map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for name, id := range names {
+		r, ok := s.rules[name]
+		if !ok || name == id {
+			continue
+		}
+		if _, exists := s.rules[id]; !exists {
+			s.rules[id] = r
+		}
+		delete(s.rules, name)
+		s.dirty = true
+	}
+}
+
+// Save 在有变化时写入状态文件，先写临时文件再重命名，避免中途退出损坏文件
+func (s *Store) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.dirty {
+		return nil
+	}
+	s.prune(time.Now())
+
+	data, err := json.MarshalIndent(stateFile{Rules: s.rules}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	s.dirty = false
+	return nil
+}
+
+// prune 删除超过保留期限的按日分桶
+func (s *Store) prune(now time.Time) {
+	oldest := now.AddDate(0, 0, -keepDays).Format(dayLayout)
+	for _, r := range s.rules {
+		var days []string
+		for day := range r.Daily {
+			days = append(days, day)
+		}
+		sort.Strings(days)
+		for _, day := range days {
+			if day >= oldest {
+				break
+			}
+			delete(r.Daily, day)
+		}
+	}
+}
+
+// Run 每秒调用 update 更新一次累计统计，每隔 interval 写入一次文件，直到 done 关闭
+func (s *Store) Run(update func(), interval time.Duration, done <-chan struct{}) {
+	if interval <= 0 {
+		interval = DefaultFlushInterval
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	last := time.Now()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			update()
+			if now.Sub(last) >= interval {
+				s.Save()
+				last = now
+			}
+		}
+	}
+}
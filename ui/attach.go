@@ -0,0 +1,241 @@
+package ui
+
+import (
+	"fmt"
+	"gopf/config"
+	"gopf/control"
+	"gopf/forwarder"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+var (
+	attachedStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("42"))
+
+	disconnectedStyle = lipgloss.NewStyle().
+				Foreground(lipgloss.Color("203"))
+)
+
+// Host 界面连接的一个 gopf 实例
+type Host struct {
+	Name   string
+	Addr   string // 显示用的地址
+	Client *control.Client
+}
+
+// remote 界面连接的运行中 gopf。规则和转发器由对方持有，界面只显示和操作，
+// 退出界面不影响对方的规则。
+type remote struct {
+	Host
+	info     control.Info
+	rules    []forwarder.RuleStatus
+	err      error // 最近一次拉取规则的错误，不为空表示连接断开
+	fetching bool
+}
+
+func (r *remote) connected() bool {
+	return r.err == nil && r.info.Version != ""
+}
+
+// remoteRulesMsg 拉取规则的结果
+type remoteRulesMsg struct {
+	host  *remote
+	info  control.Info
+	rules []forwarder.RuleStatus
+	err   error
+}
+
+// remoteDoneMsg 远程操作的结果
+type remoteDoneMsg struct {
+	host *remote
+	err  error
+}
+
+// attached 界面是否连接到其他 gopf
+func (m *model) attached() bool {
+	return len(m.remotes) > 0
+}
+
+// multiHost 连接了多个实例时表格显示主机列，并可以按主机筛选
+func (m *model) multiHost() bool {
+	return len(m.remotes) > 1
+}
+
+// fetchRules 在后台拉取各实例的规则，上一次拉取未结束的实例跳过。
+// 连接断开的实例每次都会重试，恢复后重新读取实例信息。
+func (m *model) fetchRules() tea.Cmd {
+	var cmds []tea.Cmd
+	for _, h := range m.remotes {
+		if h.fetching {
+			continue
+		}
+		h.fetching = true
+
+		h, needInfo := h, !h.connected()
+		cmds = append(cmds, func() tea.Msg {
+			msg := remoteRulesMsg{host: h, info: h.info}
+			if needInfo {
+				if msg.info, msg.err = h.Client.Info(); msg.err != nil {
+					return msg
+				}
+			}
+			rules, err := h.Client.Rules()
+			if err != nil {
+				msg.err = err
+				return msg
+			}
+			msg.rules = make([]forwarder.RuleStatus, len(rules))
+			for i := range rules {
+				msg.rules[i] = rules[i].RuleStatus()
+			}
+			return msg
+		})
+	}
+	return tea.Batch(cmds...)
+}
+
+func (m *model) updateRemoteRules(msg remoteRulesMsg) {
+	h := msg.host
+	h.fetching = false
+	h.err = msg.err
+	// 连接断开时保留最后一次的规则，恢复后再刷新
+	if msg.err != nil {
+		return
+	}
+	h.info = msg.info
+	h.rules = msg.rules
+	m.mergeRules()
+}
+
+// mergeRules 按当前筛选的主机汇总各实例的规则
+func (m *model) mergeRules() {
+	m.rules = m.rules[:0:0]
+	m.ruleHosts = m.ruleHosts[:0:0]
+	for i, h := range m.remotes {
+		if m.hostFilter > 0 && m.hostFilter != i+1 {
+			continue
+		}
+		m.rules = append(m.rules, h.rules...)
+		for range h.rules {
+			m.ruleHosts = append(m.ruleHosts, h)
+		}
+	}
+	m.updateRows()
+}
+
+// switchHost 切换筛选的主机，0 表示全部
+func (m *model) switchHost(delta int) {
+	n := len(m.remotes) + 1
+	m.hostFilter = (m.hostFilter + delta + n) % n
+	m.table.SetCursor(0)
+	m.mergeRules()
+}
+
+// selectedHost 返回选中规则所在的实例
+func (m *model) selectedHost() *remote {
+	if idx := m.table.Cursor(); idx >= 0 && idx < len(m.ruleHosts) {
+		return m.ruleHosts[idx]
+	}
+	return m.targetHost()
+}
+
+// targetHost 返回添加规则的目标：筛选的主机，否则为选中规则所在的实例
+func (m *model) targetHost() *remote {
+	if m.hostFilter > 0 {
+		return m.remotes[m.hostFilter-1]
+	}
+	if idx := m.table.Cursor(); idx >= 0 && idx < len(m.ruleHosts) {
+		return m.ruleHosts[idx]
+	}
+	return m.remotes[0]
+}
+
+// remoteDo 在后台对实例执行操作，完成后刷新规则
+func (m *model) remoteDo(h *remote, fn func(c *control.Client) error) tea.Cmd {
+	return func() tea.Msg {
+		return remoteDoneMsg{host: h, err: fn(h.Client)}
+	}
+}
+
+// remoteKey 处理连接模式下作用于选中规则的按键，ok 为 false 时按本地方式处理（编辑、删除确认）
+func (m *model) remoteKey(key string) (cmd tea.Cmd, ok bool) {
+	rule := m.rules[m.table.Cursor()]
+	name := rule.Name
+	h := m.selectedHost()
+
+	switch key {
+	case "s":
+		m.err = nil
+		return m.remoteDo(h, func(c *control.Client) error {
+			if rule.Running {
+				return c.StopRule(name)
+			}
+			return c.StartRule(name)
+		}), true
+	case "c":
+		m.err = nil
+		return m.remoteDo(h, func(c *control.Client) error {
+			return c.ClearStats(name, false)
+		}), true
+	case "p", "i", "x":
+		// 抓包、检视和故障注入需要直接访问转发器
+		m.err = fmt.Errorf(m.tr("attach_unsupported"))
+		return nil, true
+	}
+	return nil, false
+}
+
+// remoteView 显示连接的实例及连接状态，多个实例时显示主机切换栏
+func (m *model) remoteView() string {
+	if !m.multiHost() {
+		h := m.remotes[0]
+		if h.err != nil {
+			return disconnectedStyle.Render(fmt.Sprintf(m.tr("attach_lost"), h.Addr, h.err))
+		}
+		return attachedStyle.Render(fmt.Sprintf(m.tr("attached"), h.Addr, h.info.Mode, h.info.Version))
+	}
+
+	tabs := []string{m.hostTab(m.tr("all_hosts"), m.hostFilter == 0)}
+	var lost []string
+	for i, h := range m.remotes {
+		dot := attachedStyle.Render("●")
+		if !h.connected() {
+			dot = disconnectedStyle.Render("○")
+		}
+		tabs = append(tabs, dot+" "+m.hostTab(h.Name, m.hostFilter == i+1))
+		if h.err != nil {
+			lost = append(lost, disconnectedStyle.Render(fmt.Sprintf(m.tr("attach_lost"), h.Name+" ("+h.Addr+")", h.err)))
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(labelStyle.Render(m.tr("host")+": ") + strings.Join(tabs, "  "))
+	b.WriteString("  " + fmt.Sprintf(m.tr("hosts_hint"), keyStyle.Render("[tab]")))
+	for _, l := range lost {
+		b.WriteString("\n" + l)
+	}
+	return b.String()
+}
+
+func (m *model) hostTab(name string, selected bool) string {
+	if selected {
+		return selectedButtonStyle.Padding(0, 1).Render(name)
+	}
+	return name
+}
+
+// AttachUI 运行连接到其他 gopf 的界面，可以同时连接多个实例。
+// 界面退出只是断开连接，对方的规则继续运行。
+func AttachUI(hosts []Host, version string) error {
+	m := NewModel(&config.Config{}, forwarder.NewManager(nil), &forwarder.Env{}, version)
+	for _, h := range hosts {
+		m.remotes = append(m.remotes, &remote{Host: h})
+	}
+	m.updateTable()
+
+	_, err := tea.NewProgram(m, tea.WithAltScreen()).Run()
+	return err
+}
@@ -0,0 +1,139 @@
+package ui
+
+import (
+	"fmt"
+	"gopf/config"
+	"gopf/control"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// controlMsg 控制接口的操作，在界面的事件循环中执行，避免与按键操作并发修改规则
+type controlMsg struct {
+	fn    func(m *model) error
+	reply chan error
+}
+
+// controlBackend 通过界面执行控制接口的请求
+type controlBackend struct {
+	p    *tea.Program
+	done chan struct{} // 界面退出后关闭
+}
+
+func (b *controlBackend) do(fn func(m *model) error) error {
+	reply := make(chan error, 1)
+	go b.p.Send(controlMsg{fn: fn, reply: reply})
+	select {
+	case err := <-reply:
+		return err
+	case <-b.done:
+		return control.ErrUnavailable
+	}
+}
+
+// withRule 按名称查找规则后以规则 ID 执行 fn
+func (b *controlBackend) withRule(name string, fn func(m *model, id string) error) error {
+	return b.do(func(m *model) error {
+		id := m.ruleID(name)
+		if id == "" {
+			return control.ErrNotFound
+		}
+		return fn(m, id)
+	})
+}
+
+// doOp 在事件循环中执行 fn 修改配置，再在当前协程中执行它返回的规则操作，
+// 启动规则等耗时操作不会卡住界面。返回操作与保存配置中的第一个错误
+func (b *controlBackend) doOp(name string, fn func(m *model, id string) (ruleOp, error)) error {
+	var (
+		op      ruleOp
+		saveErr error
+	)
+	err := b.withRule(name, func(m *model, id string) error {
+		op, saveErr = fn(m, id)
+		if op == nil {
+			return saveErr
+		}
+		return nil
+	})
+	if err != nil || op == nil {
+		return err
+	}
+	if err := op(); err != nil {
+		return err
+	}
+	return saveErr
+}
+
+func (b *controlBackend) Rules() ([]control.Rule, error) {
+	var rules []control.Rule
+	err := b.do(func(m *model) error {
+		list := m.manager.Snapshot()
+		rules = make([]control.Rule, len(list))
+		for i := range list {
+			rules[i] = control.RuleOf(list[i])
+		}
+		return nil
+	})
+	return rules, err
+}
+
+func (b *controlBackend) AddRule(rule config.ForwardRule, start bool) error {
+	// 新规则还没有名称对应的 ID，这里不经过 withRule
+	var op ruleOp
+	err := b.do(func(m *model) (err error) {
+		op, err = m.addRule(rule, start)
+		return err
+	})
+	if err != nil || op == nil {
+		return err
+	}
+	return op()
+}
+
+func (b *controlBackend) UpdateRule(name string, rule config.ForwardRule) error {
+	return b.doOp(name, func(m *model, id string) (ruleOp, error) {
+		return m.updateRule(id, rule)
+	})
+}
+
+func (b *controlBackend) DeleteRule(name string) error {
+	return b.doOp(name, func(m *model, id string) (ruleOp, error) {
+		return m.deleteRule(id)
+	})
+}
+
+func (b *controlBackend) StartRule(name string) error {
+	return b.doOp(name, func(m *model, id string) (ruleOp, error) {
+		return m.setRunning(id, true)
+	})
+}
+
+func (b *controlBackend) StopRule(name string) error {
+	return b.doOp(name, func(m *model, id string) (ruleOp, error) {
+		return m.setRunning(id, false)
+	})
+}
+
+func (b *controlBackend) ClearStats(name string, lifetime bool) error {
+	return b.withRule(name, func(m *model, id string) error {
+		if lifetime {
+			if m.store == nil {
+				return fmt.Errorf("未启用累计统计")
+			}
+			m.store.Reset(id)
+			return nil
+		}
+		return m.manager.ClearStats(id)
+	})
+}
+
+// ruleID 返回指定名称的规则 ID，不存在时返回空字符串
+func (m *model) ruleID(name string) string {
+	for i := range m.config.Rules {
+		if m.config.Rules[i].Name == name {
+			return m.config.Rules[i].ID
+		}
+	}
+	return ""
+}
@@ -0,0 +1,104 @@
+package ui
+
+import (
+	"fmt"
+	"gopf/forwarder"
+	"strings"
+	"time"
+)
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// detailView 显示选中规则的速率曲线，累计模式下还显示今日和本月的流量
+func (m *model) detailView() string {
+	if len(m.rules) == 0 {
+		return ""
+	}
+
+	rule := m.rules[m.table.Cursor()]
+
+	var b strings.Builder
+	if m.lifetime {
+		now := time.Now()
+		st := m.store.Get(rule.ID)
+		day, month := st.Day(now), st.Month(now)
+		since := "-"
+		if !st.ResetAt.IsZero() {
+			since = st.ResetAt.Format("2006-01-02 15:04")
+		}
+		b.WriteString("\n" + labelStyle.Render(fmt.Sprintf(m.tr("detail_lifetime"), since,
+			formatBytes(day.BytesSent), formatBytes(day.BytesRecv),
+			formatBytes(month.BytesSent), formatBytes(month.BytesRecv),
+			st.Total.Connections)))
+	}
+
+	// 连接到其他 gopf 时没有速率曲线，只显示延迟
+	if f := m.manager.Forwarder(rule.ID); f != nil {
+		width := m.width - 6
+		if width < 10 {
+			width = 10
+		}
+
+		sent, recv := f.RateHistory()
+		if len(sent) > width {
+			sent = sent[len(sent)-width:]
+			recv = recv[len(recv)-width:]
+		}
+
+		b.WriteString("\n" + labelStyle.Render(fmt.Sprintf(m.tr("detail_rates"),
+			rule.Name, formatRate(rule.RateSent), formatRate(rule.RateRecv), len(sent))))
+		b.WriteString("\n" + upStyle.Render("↑ "+sparkline(sent)))
+		b.WriteString("\n" + downStyle.Render("↓ "+sparkline(recv)))
+	} else if !m.attached() {
+		return b.String()
+	}
+
+	var parts []string
+	for _, l := range []struct {
+		key string
+		lat forwarder.Latency
+	}{
+		{"latency_dial", rule.DialLatency},
+		{"latency_tls", rule.TLSLatency},
+		{"latency_ttfb", rule.TTFB},
+	} {
+		if l.lat.Count > 0 {
+			parts = append(parts, fmt.Sprintf("%s %s/%s/%s", m.tr(l.key),
+				formatLatency(l.lat.P50), formatLatency(l.lat.P95), formatLatency(l.lat.P99)))
+		}
+	}
+	if len(parts) > 0 {
+		b.WriteString("\n" + labelStyle.Render(fmt.Sprintf(m.tr("detail_latency"), strings.Join(parts, "  "))))
+	}
+	return b.String()
+}
+
+// formatLatency 以合适的单位显示延迟
+func formatLatency(d time.Duration) string {
+	switch {
+	case d < time.Millisecond:
+		return fmt.Sprintf("%dµs", d.Microseconds())
+	case d < time.Second:
+		return fmt.Sprintf("%.1fms", float64(d)/float64(time.Millisecond))
+	default:
+		return fmt.Sprintf("%.2fs", d.Seconds())
+	}
+}
+
+// sparkline 将样本按最大值缩放为一行方块字符
+func sparkline(samples []uint64) string {
+	var peak uint64
+	for _, v := range samples {
+		peak = max(peak, v)
+	}
+
+	line := make([]rune, len(samples))
+	for i, v := range samples {
+		if peak == 0 {
+			line[i] = sparkBlocks[0]
+			continue
+		}
+		line[i] = sparkBlocks[v*uint64(len(sparkBlocks)-1)/peak]
+	}
+	return string(line)
+}
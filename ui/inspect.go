@@ -0,0 +1,210 @@
+package ui
+
+import (
+	"fmt"
+	"gopf/forwarder"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+const (
+	inspectBuffer    = 256                    // 观察者事件队列长度
+	inspectMaxEvents = 500                    // 保留的最近事件数
+	inspectMaxBytes  = 512                    // 每个事件最多显示的字节数
+	inspectInterval  = 200 * time.Millisecond // 刷新间隔
+)
+
+var (
+	// 客户端发往上游为绿色，上游发往客户端为蓝色
+	upStyle = lipgloss.NewStyle().
+		Foreground(lipgloss.Color("42"))
+
+	downStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("39"))
+)
+
+// inspector 实时查看选中规则转发的数据
+type inspector struct {
+	rule   string
+	f      *forwarder.Forwarder
+	tap    *forwarder.Tap
+	events []forwarder.TapEvent
+	conns  []uint64
+	filter int // conns 中的下标，-1 表示全部连接
+	paused bool
+}
+
+type inspectTickMsg time.Time
+
+func inspectTick() tea.Cmd {
+	return tea.Tick(inspectInterval, func(t time.Time) tea.Msg {
+		return inspectTickMsg(t)
+	})
+}
+
+func (m *model) openInspector(name string, f *forwarder.Forwarder) tea.Cmd {
+	m.inspect = &inspector{
+		rule:   name,
+		f:      f,
+		tap:    f.AttachTap(inspectBuffer),
+		filter: -1,
+	}
+	m.mode = inspectMode
+	return inspectTick()
+}
+
+func (m *model) closeInspector() {
+	if m.inspect != nil {
+		m.inspect.f.DetachTap(m.inspect.tap)
+		m.inspect = nil
+	}
+	m.mode = normalMode
+}
+
+// drain 取出观察者中积压的事件，暂停时直接丢弃
+func (in *inspector) drain() {
+	for {
+		select {
+		case ev := <-in.tap.Events():
+			if in.paused {
+				continue
+			}
+			in.events = append(in.events, ev)
+			if len(in.events) > inspectMaxEvents {
+				in.events = in.events[len(in.events)-inspectMaxEvents:]
+			}
+			if !in.hasConn(ev.Conn) {
+				in.conns = append(in.conns, ev.Conn)
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (in *inspector) hasConn(id uint64) bool {
+	for _, c := range in.conns {
+		if c == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *model) updateInspector(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	in := m.inspect
+	switch msg.String() {
+	case "esc", "q", "i":
+		m.closeInspector()
+	case "ctrl+c":
+		m.closeInspector()
+		return m, tea.Quit
+	case " ", "p":
+		in.paused = !in.paused
+	case "tab", "f":
+		in.filter++
+		if in.filter >= len(in.conns) {
+			in.filter = -1
+		}
+	case "shift+tab", "F":
+		in.filter--
+		if in.filter < -1 {
+			in.filter = len(in.conns) - 1
+		}
+	case "c", "x":
+		in.events = nil
+		in.conns = nil
+		in.filter = -1
+	}
+	return m, nil
+}
+
+func (m *model) inspectView() string {
+	in := m.inspect
+
+	conn := m.tr("inspect_all")
+	if in.filter >= 0 {
+		conn = fmt.Sprintf("#%d", in.conns[in.filter])
+	}
+	header := fmt.Sprintf(m.tr("inspect_title"), in.rule, conn, in.tap.Drops())
+	if in.paused {
+		header += "  " + warningStyle.Render(m.tr("inspect_paused"))
+	}
+
+	var lines []string
+	for _, ev := range in.events {
+		if in.filter >= 0 && ev.Conn != in.conns[in.filter] {
+			continue
+		}
+
+		style, arrow := downStyle, "←"
+		if ev.FromClient {
+			style, arrow = upStyle, "→"
+		}
+		lines = append(lines, style.Bold(true).Render(fmt.Sprintf("#%d %s %s %s %d B",
+			ev.Conn, ev.Client, arrow, ev.Time.Format("15:04:05.000"), len(ev.Data))))
+
+		data := ev.Data
+		if len(data) > inspectMaxBytes {
+			data = data[:inspectMaxBytes]
+		}
+		for _, line := range hexDump(data) {
+			lines = append(lines, style.Render(line))
+		}
+		if len(ev.Data) > len(data) {
+			lines = append(lines, style.Render(fmt.Sprintf(m.tr("inspect_more"), len(ev.Data)-len(data))))
+		}
+	}
+
+	// 只显示能放下的最新内容
+	height := m.height - 5
+	if height < 5 {
+		height = 5
+	}
+	if len(lines) > height {
+		lines = lines[len(lines)-height:]
+	}
+
+	hint := fmt.Sprintf(m.tr("inspect_hint"),
+		keyStyle.Render("[space]"),
+		keyStyle.Render("[tab]"),
+		keyStyle.Render("[c]"),
+		keyStyle.Render("[esc]"),
+	)
+	return labelStyle.Render(header) + "\n" + strings.Join(lines, "\n") + "\n" + hint
+}
+
+// hexDump 以 hexdump -C 的格式输出数据
+func hexDump(data []byte) []string {
+	var lines []string
+	for off := 0; off < len(data); off += 16 {
+		row := data[off:min(off+16, len(data))]
+
+		var hex strings.Builder
+		for i := 0; i < 16; i++ {
+			if i < len(row) {
+				fmt.Fprintf(&hex, "%02x ", row[i])
+			} else {
+				hex.WriteString("   ")
+			}
+			if i == 7 {
+				hex.WriteByte(' ')
+			}
+		}
+
+		ascii := make([]byte, len(row))
+		for i, b := range row {
+			if b >= 0x20 && b < 0x7f {
+				ascii[i] = b
+			} else {
+				ascii[i] = '.'
+			}
+		}
+
+		lines = append(lines, fmt.Sprintf("%08x  %s |%s|", off, hex.String(), ascii))
+	}
+	return lines
+}
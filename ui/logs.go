@@ -0,0 +1,96 @@
+package ui
+
+import (
+	"fmt"
+	"gopf/logging"
+	"log/slog"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+var logLevelStyles = map[slog.Level]lipgloss.Style{
+	slog.LevelDebug: lipgloss.NewStyle().Foreground(lipgloss.Color("240")),
+	slog.LevelInfo:  lipgloss.NewStyle(),
+	slog.LevelWarn:  lipgloss.NewStyle().Foreground(lipgloss.Color("214")),
+	slog.LevelError: lipgloss.NewStyle().Foreground(lipgloss.Color("203")),
+}
+
+// logPane 查看最近的日志，可只看选中的规则
+type logPane struct {
+	rule string // 为空表示全部规则
+}
+
+func (m *model) openLogs() {
+	m.logPane = &logPane{}
+	m.mode = logMode
+}
+
+func (m *model) updateLogs(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "esc", "q", "v":
+		m.logPane = nil
+		m.mode = normalMode
+	case "ctrl+c":
+		return m, tea.Quit
+	case "tab", "f":
+		if m.logPane.rule == "" && len(m.rules) > 0 {
+			m.logPane.rule = m.rules[m.table.Cursor()].Name
+		} else {
+			m.logPane.rule = ""
+		}
+	case "c", "x":
+		m.logs.Ring().Clear()
+	}
+	return m, nil
+}
+
+func (m *model) logsView() string {
+	filter := m.tr("inspect_all")
+	if m.logPane.rule != "" {
+		filter = m.logPane.rule
+	}
+
+	var lines []string
+	for _, e := range m.logs.Ring().Entries() {
+		if m.logPane.rule != "" && e.Rule != m.logPane.rule {
+			continue
+		}
+		lines = append(lines, formatEntry(e))
+	}
+
+	// 只显示能放下的最新内容
+	height := m.height - 4
+	if height < 5 {
+		height = 5
+	}
+	if len(lines) > height {
+		lines = lines[len(lines)-height:]
+	}
+
+	hint := fmt.Sprintf(m.tr("logs_hint"),
+		keyStyle.Render("[tab]"),
+		keyStyle.Render("[c]"),
+		keyStyle.Render("[esc]"),
+	)
+	header := labelStyle.Render(fmt.Sprintf(m.tr("logs_title"), filter))
+	return header + "\n" + strings.Join(lines, "\n") + "\n" + hint
+}
+
+func formatEntry(e logging.Entry) string {
+	style, ok := logLevelStyles[e.Level]
+	if !ok {
+		style = lipgloss.NewStyle()
+	}
+
+	line := e.Time.Format("15:04:05") + " " + fmt.Sprintf("%-5s", e.Level.String())
+	if e.Rule != "" {
+		line += " [" + e.Rule + "]"
+	}
+	line += " " + e.Message
+	if e.Attrs != "" {
+		line += " " + e.Attrs
+	}
+	return style.Render(line)
+}
@@ -0,0 +1,58 @@
+package ui
+
+import (
+	"gopf/config"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// reloadMsg 配置文件有变化或收到 SIGHUP，需要重新加载
+type reloadMsg struct{}
+
+// configLoadedMsg 重新读取配置文件的结果
+type configLoadedMsg struct {
+	cfg *config.Config
+	err error
+}
+
+// loadConfig 在后台重新读取配置文件
+func (m *model) loadConfig() tea.Cmd {
+	path := m.config.Path()
+	return func() tea.Msg {
+		cfg, err := config.ReadConfig(path)
+		return configLoadedMsg{cfg: cfg, err: err}
+	}
+}
+
+// applyConfig 只启停有变化的规则，未变化的规则及其连接不受影响；配置有误时保留当前规则。
+// 返回的命令在事件循环外启停规则
+func (m *model) applyConfig(msg configLoadedMsg) tea.Cmd {
+	if msg.err != nil {
+		m.reloadErr = msg.err
+		m.logs.Slog().Error("重新加载配置失败，继续使用当前配置", "event", "reload_failed", "err", msg.err)
+		return nil
+	}
+	m.reloadErr = nil
+
+	diff := m.config.Apply(msg.cfg)
+	rules := append([]config.ForwardRule(nil), m.config.Rules...)
+	mgr, log := m.manager, m.logs.Slog()
+	sync := func() error {
+		if err := mgr.Sync(rules); err != nil {
+			log.Warn("端口转发启动失败", "event", "rule_failed", "err", err)
+		}
+		return nil
+	}
+	// 写回为新规则生成的 ID
+	if diff.NewIDs {
+		if err := m.config.SaveIDs(); err != nil {
+			m.logs.Slog().Warn("保存配置失败", "event", "save_failed", "err", err)
+		}
+	}
+	m.refreshRules()
+	if !diff.Empty() {
+		m.logs.Slog().Info("已重新加载配置", "event", "reloaded",
+			"added", len(diff.Added), "removed", len(diff.Removed), "changed", len(diff.Changed))
+	}
+	return runRuleOp(sync)
+}
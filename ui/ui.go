@@ -3,10 +3,12 @@ package ui
 import (
 	"fmt"
 	"gopf/config"
+	"gopf/control"
 	"gopf/forwarder"
+	"gopf/logging"
+	"gopf/stats"
 	"strconv"
 	"strings"
-	"sync/atomic"
 	"time"
 
 	"github.com/charmbracelet/bubbles/table"
@@ -75,13 +77,15 @@ const (
 	addMode
 	editMode
 	confirmMode
+	inspectMode
+	logMode
 )
 
 type model struct {
 	table      table.Model
 	config     *config.Config
-	rules      []config.ForwardRule
-	forwarders map[string]*forwarder.Forwarder
+	rules      []forwarder.RuleStatus // 表格显示的规则，来自 manager 或连接的实例
+	manager    *forwarder.Manager
 	language   config.Language
 	mode       mode
 	inputs     []inputField
@@ -92,6 +96,16 @@ type model struct {
 	version    string
 	width      int
 	height     int
+	inspect    *inspector
+	env        *forwarder.Env
+	store      *stats.Store
+	lifetime   bool // 流量列显示累计统计而非本次会话
+	logs       *logging.Logger
+	logPane    *logPane
+	reloadErr  error     // 最近一次重新加载配置的错误，成功加载后清除
+	remotes    []*remote // 不为空时界面连接到其他 gopf，不持有转发器
+	ruleHosts  []*remote // 连接模式下每条规则所在的实例
+	hostFilter int       // 只显示第 hostFilter 个实例的规则，0 表示全部
 }
 
 var translations = map[config.Language]map[string]string{
@@ -101,15 +115,16 @@ var translations = map[config.Language]map[string]string{
 		"remote_addr":        "远程地址",
 		"status":             "状态",
 		"connections":        "连接数",
-		"forward_count":      "转发数",
+		"forward_count":      "数据块",
 		"bytes_sent":         "发送流量",
 		"bytes_recv":         "接收流量",
 		"status_ok":          "正常",
 		"status_fail":        "失败",
 		"running":            "运行中",
 		"stopped":            "已停止",
+		"disabled":           "已停用",
 		"exit_hint":          "按 %s 退出",
-		"normal_hint":        "操作：%s添加 %s编辑 %s删除 %s启动/停止 %s清空统计 %sEnglish %s退出",
+		"normal_hint":        "操作：%s添加 %s编辑 %s删除 %s启动/停止 %s清空统计 %s抓包 %s检视 %s故障注入 %s本次/累计 %s日志 %sEnglish %s退出",
 		"edit_hint":          "编辑模式：%s确认 %s取消 %s切换字段",
 		"add_hint":           "添加模式：%s确认 %s取消 %s切换字段",
 		"name_label":         "名称：",
@@ -142,6 +157,41 @@ var translations = map[config.Language]map[string]string{
 		"err_port_range":     "端口必须在 1-65535 之间",
 		"err_empty_host":     "主机地址不能为空",
 		"please_select_rule": "请先选择一个规则",
+		"relay_target":       "中继 (等待注册)",
+		"ratio":              "压缩率",
+		"rate_sent":          "上行速度",
+		"rate_recv":          "下行速度",
+		"detail_rates":       "%s  ↑ %s  ↓ %s  （最近 %d 秒）",
+		"mirror_stats":       "%s: 镜像 %s 丢弃 %d 次，错误 %d 次",
+		"capturing":          "%s: 抓包中 → %s",
+		"capture_stopped":    "规则未运行，无法抓包",
+		"inspect_stopped":    "规则未运行，无法检视",
+		"inspect_title":      "检视：%s  连接：%s  丢弃：%d",
+		"inspect_all":        "全部",
+		"inspect_paused":     "已暂停",
+		"inspect_more":       "… 另有 %d 字节",
+		"inspect_hint":       "%s暂停/继续 %s切换连接 %s清空 %s返回",
+		"chaos_stopped":      "规则未运行，无法注入故障",
+		"chaos_stats":        "%s: 故障注入中 延迟 %d 次，重置 %d 次，篡改 %d 次，截断 %d 次",
+		"quota":              "配额",
+		"over_quota":         "超出配额",
+		"detail_latency":     "延迟 p50/p95/p99  %s",
+		"latency_dial":       "连接",
+		"latency_tls":        "TLS 握手",
+		"latency_ttfb":       "首字节",
+		"logs_title":         "日志：%s",
+		"logs_hint":          "%s全部/选中规则 %s清空 %s返回",
+		"lifetime_sent":      "累计发送",
+		"lifetime_recv":      "累计接收",
+		"stats_disabled":     "未配置 stats，无法显示累计统计",
+		"detail_lifetime":    "累计（自 %s）：今日 ↑ %s ↓ %s  本月 ↑ %s ↓ %s  共 %d 个连接",
+		"attached":           "已连接 %s（%s %s），退出界面后规则继续运行",
+		"attach_lost":        "与 %s 的连接已断开，正在重连：%v",
+		"attach_unsupported": "连接模式下不支持此操作",
+		"host":               "主机",
+		"reload_failed":      "重新加载配置失败，继续使用当前配置：%v",
+		"all_hosts":          "全部",
+		"hosts_hint":         "%s切换主机，退出界面后规则继续运行",
 	},
 	config.English: {
 		"name":               "Name",
@@ -149,15 +199,16 @@ var translations = map[config.Language]map[string]string{
 		"remote_addr":        "Remote Addr",
 		"status":             "Status",
 		"connections":        "Connections",
-		"forward_count":      "Forwards",
+		"forward_count":      "Chunks",
 		"bytes_sent":         "Bytes Sent",
 		"bytes_recv":         "Bytes Recv",
 		"status_ok":          "OK",
 		"status_fail":        "Failed",
 		"running":            "Running",
 		"stopped":            "Stopped",
+		"disabled":           "Disabled",
 		"exit_hint":          "Press %s to exit",
-		"normal_hint":        "Commands: %sAdd %sEdit %sDelete %sStart/Stop %sClear Stats %s中文 %sExit",
+		"normal_hint":        "Commands: %sAdd %sEdit %sDelete %sStart/Stop %sClear Stats %sCapture %sInspect %sChaos %sSession/Lifetime %sLogs %s中文 %sExit",
 		"edit_hint":          "Edit Mode: %sConfirm %sCancel %sSwitch Field",
 		"add_hint":           "Add Mode: %sConfirm %sCancel %sSwitch Field",
 		"name_label":         "Name: ",
@@ -190,6 +241,41 @@ var translations = map[config.Language]map[string]string{
 		"err_port_range":     "Port must be between 1-65535",
 		"err_empty_host":     "Host address cannot be empty",
 		"please_select_rule": "Please select a rule first",
+		"relay_target":       "relay (awaiting registrations)",
+		"ratio":              "Ratio",
+		"rate_sent":          "Up",
+		"rate_recv":          "Down",
+		"detail_rates":       "%s  ↑ %s  ↓ %s  (last %ds)",
+		"mirror_stats":       "%s: mirror %s dropped %d, errors %d",
+		"capturing":          "%s: capturing → %s",
+		"capture_stopped":    "Rule is not running, cannot capture",
+		"inspect_stopped":    "Rule is not running, cannot inspect",
+		"inspect_title":      "Inspect: %s  Connection: %s  Dropped: %d",
+		"inspect_all":        "all",
+		"inspect_paused":     "paused",
+		"inspect_more":       "… %d more bytes",
+		"inspect_hint":       "%sPause/Resume %sSwitch Connection %sClear %sBack",
+		"chaos_stopped":      "Rule is not running, cannot inject faults",
+		"chaos_stats":        "%s: chaos on, delayed %d, reset %d, corrupted %d, truncated %d",
+		"quota":              "Quota",
+		"over_quota":         "Quota exceeded",
+		"detail_latency":     "Latency p50/p95/p99  %s",
+		"latency_dial":       "dial",
+		"latency_tls":        "TLS handshake",
+		"latency_ttfb":       "first byte",
+		"logs_title":         "Logs: %s",
+		"logs_hint":          "%sAll/Selected Rule %sClear %sBack",
+		"lifetime_sent":      "Total Sent",
+		"lifetime_recv":      "Total Recv",
+		"stats_disabled":     "stats is not configured, lifetime totals unavailable",
+		"detail_lifetime":    "Lifetime (since %s): today ↑ %s ↓ %s  this month ↑ %s ↓ %s  %d connections",
+		"attached":           "Attached to %s (%s %s), rules keep running after exit",
+		"attach_lost":        "Lost connection to %s, reconnecting: %v",
+		"attach_unsupported": "Not available while attached",
+		"host":               "Host",
+		"reload_failed":      "Failed to reload config, keeping the current rules: %v",
+		"all_hosts":          "All",
+		"hosts_hint":         "%s switch host, rules keep running after exit",
 	},
 }
 
@@ -200,6 +286,17 @@ func (m *model) tr(key string) string {
 	return key
 }
 
+// ruleTarget 返回表格中显示的远程地址，反向规则显示中继地址及公网端口
+func (m *model) ruleTarget(rule config.ForwardRule) string {
+	switch rule.Kind() {
+	case config.RuleRelay:
+		return m.tr("relay_target")
+	case config.RuleReverse:
+		return fmt.Sprintf("%s:%d ← :%d", rule.RemoteHost, rule.RemotePort, rule.PublicPort)
+	}
+	return fmt.Sprintf("%s:%d", rule.RemoteHost, rule.RemotePort)
+}
+
 func formatLastActive(lastActive int64, tr func(string) string) string {
 	if lastActive == 0 {
 		return tr("never")
@@ -222,11 +319,22 @@ func formatLastActive(lastActive int64, tr func(string) string) string {
 	}
 }
 
-func NewModel(cfg *config.Config, forwarders map[string]*forwarder.Forwarder, version string) *model {
+// bytesTitles 返回流量列的标题，随本次/累计模式变化
+func (m *model) bytesTitles() (string, string) {
+	if m.lifetime {
+		return m.tr("lifetime_sent"), m.tr("lifetime_recv")
+	}
+	return m.tr("bytes_sent"), m.tr("bytes_recv")
+}
+
+func NewModel(cfg *config.Config, manager *forwarder.Manager, env *forwarder.Env, version string) *model {
 	m := &model{
 		config:     cfg,
-		rules:      cfg.Rules,
-		forwarders: forwarders,
+		rules:      manager.Snapshot(),
+		manager:    manager,
+		env:        env,
+		store:      env.Stats,
+		logs:       env.Logs,
 		language:   config.Chinese,
 		mode:       normalMode,
 		confirmYes: false,
@@ -269,6 +377,10 @@ func NewModel(cfg *config.Config, forwarders map[string]*forwarder.Forwarder, ve
 		{m.tr("forward_count"), 10},
 		{m.tr("bytes_sent"), 15},
 		{m.tr("bytes_recv"), 15},
+		{m.tr("rate_sent"), 12},
+		{m.tr("rate_recv"), 12},
+		{m.tr("ratio"), 8},
+		{m.tr("quota"), 8},
 		{m.tr("last_active"), 15},
 	}
 
@@ -300,22 +412,32 @@ func (m *model) updateTableColumns() {
 		availableWidth = 120 // 默认宽度
 	}
 	availableWidth -= 20 // 减去边框和padding的宽度
+	sentTitle, recvTitle := m.bytesTitles()
 
 	// 定义每列的最小宽度和权重
-	columnDefs := []struct {
+	type columnDef struct {
 		title    string
 		minWidth int
 		weight   float64
-	}{
-		{m.tr("name"), 10, 1.5},        // 名称列稍宽一些
-		{m.tr("local_port"), 8, 1},     // 本地端口列
-		{m.tr("remote_addr"), 15, 2},   // 远程地址列最宽
-		{m.tr("status"), 8, 1},         // 状态列
-		{m.tr("connections"), 6, 1},    // 连接数列
-		{m.tr("forward_count"), 6, 1},  // 转发数列
-		{m.tr("bytes_sent"), 8, 1},     // 发送流量列
-		{m.tr("bytes_recv"), 8, 1},     // 接收流量列
-		{m.tr("last_active"), 8, 1},    // 最后活跃列
+	}
+	columnDefs := []columnDef{
+		{m.tr("name"), 10, 1.5},       // 名称列稍宽一些
+		{m.tr("local_port"), 8, 1},    // 本地端口列
+		{m.tr("remote_addr"), 15, 2},  // 远程地址列最宽
+		{m.tr("status"), 8, 1},        // 状态列
+		{m.tr("connections"), 6, 1},   // 连接数列
+		{m.tr("forward_count"), 8, 1}, // 数据块列
+		{sentTitle, 8, 1},             // 发送流量列
+		{recvTitle, 8, 1},             // 接收流量列
+		{m.tr("rate_sent"), 8, 1},     // 上行速度列
+		{m.tr("rate_recv"), 8, 1},     // 下行速度列
+		{m.tr("ratio"), 6, 0.5},       // 压缩率列
+		{m.tr("quota"), 6, 0.5},       // 配额用量列
+		{m.tr("last_active"), 8, 1},   // 最后活跃列
+	}
+	// 连接多个实例时第一列为主机
+	if m.multiHost() {
+		columnDefs = append([]columnDef{{m.tr("host"), 8, 1}}, columnDefs...)
 	}
 
 	// 计算所有列的最小宽度总和
@@ -394,26 +516,46 @@ func (m *model) updateRows() {
 	}
 
 	var rows []table.Row
-	for _, rule := range m.rules {
+	for i, rule := range m.rules {
 		status := m.tr("running")
-		if !rule.IsRunning {
+		if !rule.Running {
 			status = m.tr("stopped")
+			if rule.Disabled {
+				status = m.tr("disabled")
+			}
+		}
+		if rule.Running && rule.OverQuota {
+			status = m.tr("over_quota")
 		}
 		if rule.Error != "" {
 			status = m.tr("status_fail")
 		}
 
-		rows = append(rows, table.Row{
+		sent, recv := rule.BytesSent, rule.BytesRecv
+		if m.lifetime {
+			total := m.store.Get(rule.ID).Total
+			sent, recv = total.BytesSent, total.BytesRecv
+		}
+
+		row := table.Row{
 			rule.Name,
 			fmt.Sprintf("%d", rule.LocalPort),
-			fmt.Sprintf("%s:%d", rule.RemoteHost, rule.RemotePort),
+			m.ruleTarget(rule.ForwardRule),
 			status,
 			fmt.Sprintf("%d", rule.Connections),
-			fmt.Sprintf("%d", rule.ForwardCount),
-			formatBytes(rule.BytesSent),
-			formatBytes(rule.BytesRecv),
+			fmt.Sprintf("%d/%d", rule.ChunksSent, rule.ChunksRecv),
+			formatBytes(sent),
+			formatBytes(recv),
+			formatRate(rule.RateSent),
+			formatRate(rule.RateRecv),
+			formatRatio(rule),
+			formatQuota(rule),
 			formatLastActive(rule.LastActive, m.tr),
-		})
+		}
+		if m.multiHost() {
+			row = append(table.Row{m.ruleHosts[i].Name}, row...)
+		}
+		rows = append(rows, row)
 	}
 
 	// 如果没有数据，添加一个空行
@@ -438,65 +580,136 @@ func (m *model) updateRows() {
 	}
 }
 
-func (m *model) startForwarder(rule *config.ForwardRule) error {
-	// 如果已经在运行，先停止
-	if rule.IsRunning {
-		m.stopForwarder(rule)
+// refreshRules 从 manager 读取各规则的最新状态，连接模式下规则由 fetchRules 更新
+func (m *model) refreshRules() {
+	if !m.attached() {
+		m.rules = m.manager.Snapshot()
 	}
+	m.updateRows()
+}
+
+// 添加、修改、删除和启停规则分两步：在事件循环中修改并保存配置，返回的 ruleOp 再操作 manager。
+// 启动 reverse 规则时连接中继最长需要 10 秒，ruleOp 需在事件循环外执行（见 runRuleOp），
+// 以免卡住界面。
+
+// ruleOp 对 manager 执行的规则操作，可能耗时
+type ruleOp func() error
+
+// ruleOpMsg 在后台执行的规则操作已完成
+type ruleOpMsg struct{ err error }
 
-	// 创建新的转发器
-	f := forwarder.NewForwarder(rule)
-	if err := f.Start(); err != nil {
-		rule.IsRunning = false
-		return err
+// runRuleOp 在事件循环外执行规则操作，完成后以 ruleOpMsg 通知界面
+func runRuleOp(op ruleOp) tea.Cmd {
+	if op == nil {
+		return nil
 	}
+	return func() tea.Msg {
+		return ruleOpMsg{err: op()}
+	}
+}
 
-	rule.IsRunning = true
-	rule.Error = ""
-	m.forwarders[rule.Name] = f
-	return nil
+// addRule 添加规则并保存，start 为 true 时返回的操作启动规则，启动失败记录在规则的状态中；
+// start 为 false 时规则保存为停用
+func (m *model) addRule(rule config.ForwardRule, start bool) (ruleOp, error) {
+	rule.Disabled = !start
+	if err := m.config.AddRule(rule); err != nil {
+		return nil, err
+	}
+	added := m.config.Rules[len(m.config.Rules)-1]
+	if err := m.manager.Put(added); err != nil {
+		return nil, err
+	}
+	m.refreshRules()
+	if !start {
+		return nil, nil
+	}
+	mgr := m.manager
+	return func() error {
+		mgr.Start(added.ID)
+		return nil
+	}, nil
 }
 
-func (m *model) stopForwarder(rule *config.ForwardRule) {
-	// 通过规则名称查找并停止转发器
-	if f, ok := m.forwarders[rule.Name]; ok {
-		f.Stop()
-		delete(m.forwarders, rule.Name)
+// updateRule 修改规则并保存，返回的操作用新配置重启运行中的规则，重启失败记录在规则的状态中
+func (m *model) updateRule(id string, rule config.ForwardRule) (ruleOp, error) {
+	idx := m.config.RuleIndex(id)
+	if idx < 0 {
+		return nil, forwarder.ErrNoRule
+	}
+	if err := m.config.UpdateRule(idx, rule); err != nil {
+		return nil, err
 	}
 
-	// 通过本地端口查找并停止可能存在的其他转发器
-	for name, f := range m.forwarders {
-		if f.GetLocalPort() == rule.LocalPort {
-			f.Stop()
-			delete(m.forwarders, name)
-		}
+	updated, mgr := m.config.Rules[idx], m.manager
+	return func() error {
+		mgr.Put(updated)
+		return nil
+	}, nil
+}
+
+// deleteRule 删除规则并保存，返回的操作停止规则并删除其累计统计
+func (m *model) deleteRule(id string) (ruleOp, error) {
+	idx := m.config.RuleIndex(id)
+	if idx < 0 {
+		return nil, forwarder.ErrNoRule
 	}
+	if err := m.config.DeleteRule(idx); err != nil {
+		return nil, err
+	}
+
+	mgr, store := m.manager, m.store
+	return func() error {
+		mgr.Remove(id)
+		if store != nil {
+			store.Remove(id)
+		}
+		return nil
+	}, nil
+}
 
-	rule.IsRunning = false
-	rule.Error = ""
+// toggleRule 启动或停止规则，启动失败记录在规则的状态中
+func (m *model) toggleRule(rule forwarder.RuleStatus) tea.Cmd {
+	op, err := m.setRunning(rule.ID, !rule.Running)
+	if err != nil {
+		m.err = err
+	}
+	return runRuleOp(op)
 }
 
-func (m *model) clearStats(rule *config.ForwardRule) {
-	// 如果转发器正在运行，使用转发器的清空方法
-	if f, ok := m.forwarders[rule.Name]; ok {
-		f.ClearStats()
-	} else {
-		// 如果转发器没有运行，直接清空规则中的统计数据
-		atomic.StoreUint64(&rule.BytesSent, 0)
-		atomic.StoreUint64(&rule.BytesRecv, 0)
-		atomic.StoreUint64(&rule.Connections, 0)
-		atomic.StoreUint64(&rule.ForwardCount, 0)
+// setRunning 将规则保存为启用或停用，gopf 重启后保持不变，返回的操作启动或停止规则。
+// 保存失败时仍返回启停操作。
+func (m *model) setRunning(id string, run bool) (ruleOp, error) {
+	idx := m.config.RuleIndex(id)
+	if idx < 0 {
+		return nil, forwarder.ErrNoRule
 	}
+	err := m.config.SetDisabled(idx, !run)
+
+	rule, mgr := m.config.Rules[idx], m.manager
+	return func() error {
+		if run {
+			mgr.Put(rule)
+			return mgr.Start(id)
+		}
+		// 先停止再更新配置，避免 Put 按新配置重启运行中的规则
+		mgr.Stop(id)
+		mgr.Put(rule)
+		return nil
+	}, err
 }
 
 type tickMsg time.Time
 
+// ruleEventMsg manager 中的规则有变化
+type ruleEventMsg struct{}
+
 func (m *model) Init() tea.Cmd {
 	return tea.Batch(
 		tea.EnterAltScreen,
 		tea.Tick(time.Second, func(t time.Time) tea.Msg {
 			return tickMsg(t)
 		}),
+		m.fetchRules(),
 	)
 }
 
@@ -509,11 +722,42 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.height = msg.Height
 		m.updateTableColumns()
 		return m, nil
+	case inspectTickMsg:
+		if m.mode != inspectMode {
+			return m, nil
+		}
+		m.inspect.drain()
+		return m, inspectTick()
+	case controlMsg:
+		msg.reply <- msg.fn(m)
+		return m, nil
+	case reloadMsg:
+		return m, m.loadConfig()
+	case configLoadedMsg:
+		return m, m.applyConfig(msg)
+	case remoteRulesMsg:
+		m.updateRemoteRules(msg)
+		return m, nil
+	case remoteDoneMsg:
+		m.err = msg.err
+		if msg.err != nil && m.multiHost() {
+			m.err = fmt.Errorf("%s: %v", msg.host.Name, msg.err)
+		}
+		return m, m.fetchRules()
+	case ruleEventMsg:
+		m.refreshRules()
+		return m, nil
+	case ruleOpMsg:
+		if msg.err != nil {
+			m.err = msg.err
+		}
+		m.refreshRules()
+		return m, nil
 	case tickMsg:
-		m.updateRows()
-		return m, tea.Tick(time.Second, func(t time.Time) tea.Msg {
+		m.refreshRules()
+		return m, tea.Batch(tea.Tick(time.Second, func(t time.Time) tea.Msg {
 			return tickMsg(t)
-		})
+		}), m.fetchRules())
 	case tea.KeyMsg:
 		switch m.mode {
 		case normalMode:
@@ -525,6 +769,15 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				return m, cmd
 			case "q", "ctrl+c":
 				return m, tea.Quit
+			case "tab", "shift+tab":
+				if m.multiHost() {
+					if msg.String() == "tab" {
+						m.switchHost(1)
+					} else {
+						m.switchHost(-1)
+					}
+				}
+				return m, nil
 			case "L", "l":
 				if m.language == config.Chinese {
 					m.language = config.English
@@ -546,6 +799,22 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					}
 				}
 				return m, nil
+			case "t":
+				if m.store == nil {
+					m.err = fmt.Errorf(m.tr("stats_disabled"))
+					return m, nil
+				}
+				m.lifetime = !m.lifetime
+				m.err = nil
+				m.updateTable()
+				return m, nil
+			case "v":
+				if m.logs == nil {
+					return m, nil
+				}
+				m.err = nil
+				m.openLogs()
+				return m, nil
 			case "a":
 				m.mode = addMode
 				m.focusIndex = 0
@@ -559,12 +828,18 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					}
 				}
 				return m, nil
-			case "e", "d", "s", "c":
+			case "e", "d", "s", "c", "p", "i", "x":
 				if len(m.rules) == 0 {
 					m.err = fmt.Errorf(m.tr("please_select_rule"))
 					return m, nil
 				}
 
+				if m.attached() {
+					if cmd, ok := m.remoteKey(msg.String()); ok {
+						return m, cmd
+					}
+				}
+
 				switch msg.String() {
 				case "e":
 					m.mode = editMode
@@ -590,26 +865,55 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					m.mode = confirmMode
 					m.confirmYes = false
 				case "s":
-					idx := m.table.Cursor()
-					if idx >= 0 && idx < len(m.rules) {
-						rule := &m.rules[idx]
-						if rule.IsRunning {
-							m.stopForwarder(rule)
-						} else {
-							if err := m.startForwarder(rule); err != nil {
-								rule.Error = err.Error()
-							} else {
-								rule.Error = ""
-							}
-						}
-					}
+					return m, m.toggleRule(m.rules[m.table.Cursor()])
 				case "c":
-					idx := m.table.Cursor()
-					rule := &m.rules[idx]
-					m.clearStats(rule)
-					m.updateRows()
+					rule := m.rules[m.table.Cursor()]
+					// 累计模式下清空的是累计统计
+					if m.lifetime {
+						m.store.Reset(rule.ID)
+					} else {
+						m.manager.ClearStats(rule.ID)
+					}
+					m.refreshRules()
+				case "p":
+					rule := m.rules[m.table.Cursor()]
+					f := m.manager.Forwarder(rule.ID)
+					if f == nil {
+						m.err = fmt.Errorf(m.tr("capture_stopped"))
+						break
+					}
+					if f.CaptureFile() != "" {
+						f.StopCapture()
+					} else if _, err := f.StartCapture(); err != nil {
+						m.err = err
+					}
+				case "i":
+					rule := m.rules[m.table.Cursor()]
+					f := m.manager.Forwarder(rule.ID)
+					if f == nil {
+						m.err = fmt.Errorf(m.tr("inspect_stopped"))
+						break
+					}
+					m.err = nil
+					return m, m.openInspector(rule.Name, f)
+				case "x":
+					rule := m.rules[m.table.Cursor()]
+					f := m.manager.Forwarder(rule.ID)
+					if f == nil {
+						m.err = fmt.Errorf(m.tr("chaos_stopped"))
+						break
+					}
+					if err := f.SetChaos(!f.ChaosEnabled()); err != nil {
+						m.err = err
+					} else {
+						m.err = nil
+					}
 				}
 			}
+		case inspectMode:
+			return m.updateInspector(msg)
+		case logMode:
+			return m.updateLogs(msg)
 		case confirmMode:
 			switch msg.String() {
 			case "y", "Y", "right", "l":
@@ -617,16 +921,20 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			case "n", "N", "left", "h":
 				m.confirmYes = true
 			case "enter":
+				if m.confirmYes && m.attached() {
+					name := m.rules[m.table.Cursor()].Name
+					m.mode = normalMode
+					return m, m.remoteDo(m.selectedHost(), func(c *control.Client) error {
+						return c.DeleteRule(name)
+					})
+				}
 				if m.confirmYes {
-					idx := m.table.Cursor()
-					rule := &m.rules[idx]
-					m.stopForwarder(rule)
-					if err := m.config.DeleteRule(idx); err != nil {
+					op, err := m.deleteRule(m.rules[m.table.Cursor()].ID)
+					if err != nil {
 						m.err = err
-					} else {
-						m.rules = m.config.Rules
-						m.updateRows()
 					}
+					m.mode = normalMode
+					return m, runRuleOp(op)
 				}
 				m.mode = normalMode
 			case "esc":
@@ -668,8 +976,15 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					break
 				}
 
+				// 编辑时表单不包含的字段沿用原规则
 				var rule config.ForwardRule
-				var err error
+				if m.mode == editMode {
+					rule = m.rules[m.table.Cursor()].ForwardRule
+				}
+				var (
+					op  ruleOp
+					err error
+				)
 
 				rule.Name = m.inputs[0].textinput.Value()
 				rule.LocalPort, err = strconv.Atoi(m.inputs[1].textinput.Value())
@@ -684,56 +999,40 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					break
 				}
 
+				if m.mode == addMode && m.attached() {
+					m.mode = normalMode
+					m.err = nil
+					return m, m.remoteDo(m.targetHost(), func(c *control.Client) error {
+						return c.AddRule(rule, true)
+					})
+				}
+
 				if m.mode == addMode {
-					if err := m.config.AddRule(rule); err != nil {
+					if op, err = m.addRule(rule, true); err != nil {
 						m.err = err
 						break
 					}
-					m.rules = m.config.Rules
-
-					newRule := &m.rules[(len(m.rules) - 1)]
-					if err := m.startForwarder(newRule); err != nil {
-						newRule.Error = err.Error()
-					}
 				} else {
-					// 在更新规则前，先停止旧的转发器
-					idx := m.table.Cursor()
-					oldRule := &m.rules[idx]
-					wasRunning := oldRule.IsRunning
-					oldPort := oldRule.LocalPort
-
-					// 先停止旧的转发
-					if wasRunning {
-						m.stopForwarder(oldRule)
+					oldRule := m.rules[m.table.Cursor()]
+					if m.attached() {
+						name := oldRule.Name
+						m.mode = normalMode
+						m.err = nil
+						return m, m.remoteDo(m.selectedHost(), func(c *control.Client) error {
+							return c.UpdateRule(name, rule)
+						})
 					}
 
-					// 更新规则
-					if err := m.config.UpdateRule(idx, rule); err != nil {
-						// 如果更新失败，且原来是运行状态，则恢复旧的转发
-						if wasRunning {
-							oldRule.LocalPort = oldPort // 恢复旧端口
-							if err := m.startForwarder(oldRule); err != nil {
-								oldRule.Error = err.Error()
-							}
-						}
+					if op, err = m.updateRule(oldRule.ID, rule); err != nil {
 						m.err = err
 						break
 					}
-
-					m.rules = m.config.Rules
-
-					// 如果原来是运行状态，启动新的转发
-					if wasRunning {
-						newRule := &m.rules[idx]
-						if err := m.startForwarder(newRule); err != nil {
-							newRule.Error = err.Error()
-						}
-					}
 				}
 
 				m.mode = normalMode
 				m.err = nil
-				m.updateRows()
+				m.refreshRules()
+				return m, runRuleOp(op)
 			}
 		}
 	}
@@ -763,20 +1062,39 @@ func (m *model) View() string {
 		Italic(true).
 		Render(m.version)
 	view = fmt.Sprintf("%s %s\n", title, version)
+	if m.attached() {
+		view += m.remoteView() + "\n"
+	}
 
 	switch m.mode {
 	case normalMode:
 		view += baseStyle.Render(m.table.View())
+		view += m.detailView()
 
 		// 错误信息显示
 		if m.err != nil {
 			view += "\n" + errorStyle.Render(m.err.Error())
 		}
+		if m.reloadErr != nil {
+			view += "\n" + errorStyle.Render(fmt.Sprintf(m.tr("reload_failed"), m.reloadErr))
+		}
 
 		for _, rule := range m.rules {
 			if rule.Error != "" {
 				view += fmt.Sprintf("\n%s: %s", rule.Name, rule.Error)
 			}
+			if rule.Mirror != "" && (rule.MirrorDrops > 0 || rule.MirrorErrors > 0) {
+				view += "\n" + warningStyle.Render(fmt.Sprintf(m.tr("mirror_stats"), rule.Name, rule.Mirror, rule.MirrorDrops, rule.MirrorErrors))
+			}
+			if f := m.manager.Forwarder(rule.ID); f != nil {
+				if file := f.CaptureFile(); file != "" {
+					view += "\n" + labelStyle.Render(fmt.Sprintf(m.tr("capturing"), rule.Name, file))
+				}
+				if f.ChaosEnabled() {
+					view += "\n" + warningStyle.Render(fmt.Sprintf(m.tr("chaos_stats"), rule.Name,
+						rule.FaultDelays, rule.FaultResets, rule.FaultCorrupt, rule.FaultTrunc))
+				}
+			}
 		}
 
 		// 检查是否有规则
@@ -784,19 +1102,31 @@ func (m *model) View() string {
 
 		// 根据是否有规则选择按钮样式
 		addKey := keyStyle.Render("[a]")
-		var editKey, deleteKey, startStopKey, clearKey, langKey, quitKey string
+		var editKey, deleteKey, startStopKey, clearKey, captureKey, inspectKey, chaosKey, lifetimeKey, logsKey, langKey, quitKey string
 
 		if hasRules {
 			editKey = keyStyle.Render("[e]")
 			deleteKey = keyStyle.Render("[d]")
 			startStopKey = keyStyle.Render("[s]")
 			clearKey = keyStyle.Render("[c]")
+			captureKey = keyStyle.Render("[p]")
+			inspectKey = keyStyle.Render("[i]")
+			chaosKey = keyStyle.Render("[x]")
 		} else {
 			editKey = disabledButtonStyle.Render("[e]")
 			deleteKey = disabledButtonStyle.Render("[d]")
 			startStopKey = disabledButtonStyle.Render("[s]")
 			clearKey = disabledButtonStyle.Render("[c]")
+			captureKey = disabledButtonStyle.Render("[p]")
+			inspectKey = disabledButtonStyle.Render("[i]")
+			chaosKey = disabledButtonStyle.Render("[x]")
+		}
+		if m.store != nil {
+			lifetimeKey = keyStyle.Render("[t]")
+		} else {
+			lifetimeKey = disabledButtonStyle.Render("[t]")
 		}
+		logsKey = keyStyle.Render("[v]")
 		langKey = keyStyle.Render("[L]")
 		quitKey = keyStyle.Render("[q]")
 
@@ -806,6 +1136,11 @@ func (m *model) View() string {
 			deleteKey,
 			startStopKey,
 			clearKey,
+			captureKey,
+			inspectKey,
+			chaosKey,
+			lifetimeKey,
+			logsKey,
 			langKey,
 			quitKey,
 		)
@@ -832,6 +1167,13 @@ func (m *model) View() string {
 		if m.mode == editMode {
 			title = m.tr("edit_title")
 		}
+		if m.multiHost() {
+			if m.mode == editMode {
+				title += " @ " + m.selectedHost().Name
+			} else {
+				title += " @ " + m.targetHost().Name
+			}
+		}
 		b.WriteString(labelStyle.Render(title) + "\n\n")
 
 		// 渲染输入框
@@ -859,6 +1201,12 @@ func (m *model) View() string {
 		b.WriteString("\n" + hint)
 		view = b.String()
 
+	case inspectMode:
+		view += m.inspectView()
+
+	case logMode:
+		view += m.logsView()
+
 	case confirmMode:
 		// 构建确认对话框
 		var confirmBox strings.Builder
@@ -896,6 +1244,10 @@ func (m *model) View() string {
 	return view
 }
 
+func formatRate(rate uint64) string {
+	return formatBytes(rate) + "/s"
+}
+
 func formatBytes(bytes uint64) string {
 	const unit = 1024
 	if bytes < unit {
@@ -909,12 +1261,61 @@ func formatBytes(bytes uint64) string {
 	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
 }
 
-func StartUI(cfg *config.Config, forwarders map[string]*forwarder.Forwarder, version string) error {
+// formatRatio 返回压缩链路的压缩比（负载字节 / 线路字节），未压缩时显示 -
+func formatRatio(rule forwarder.RuleStatus) string {
+	wire := rule.WireSent + rule.WireRecv
+	if wire == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1fx", float64(rule.BytesSent+rule.BytesRecv)/float64(wire))
+}
+
+// formatQuota 返回配额中用量最高一项的百分比，未配置配额时显示 -
+func formatQuota(rule forwarder.RuleStatus) string {
+	q := rule.Quota
+	if q == nil {
+		return "-"
+	}
+
+	var pct float64
+	if q.Daily > 0 {
+		pct = max(pct, float64(rule.QuotaDay)/float64(q.Daily))
+	}
+	if q.Monthly > 0 {
+		pct = max(pct, float64(rule.QuotaMonth)/float64(q.Monthly))
+	}
+	if q.Connections > 0 {
+		pct = max(pct, float64(rule.QuotaConns)/float64(q.Connections))
+	}
+	return fmt.Sprintf("%.0f%%", pct*100)
+}
+
+// StartUI 运行界面，ctl 不为空时界面运行期间由界面处理控制接口的请求，
+// 从 reload 收到通知时重新加载配置文件
+func StartUI(cfg *config.Config, manager *forwarder.Manager, env *forwarder.Env, version string, ctl *control.Server, reload <-chan struct{}) error {
 	p := tea.NewProgram(
-		NewModel(cfg, forwarders, version),
+		NewModel(cfg, manager, env, version),
 		tea.WithAltScreen(),
 	)
+	go func() {
+		for range reload {
+			p.Send(reloadMsg{})
+		}
+	}()
+	// 规则在界面之外被启停时（如嵌入 gopf 的程序直接调用 manager）也立即刷新
+	events, cancel := manager.Subscribe(16)
+	defer cancel()
+	go func() {
+		for range events {
+			p.Send(ruleEventMsg{})
+		}
+	}()
+
+	backend := &controlBackend{p: p, done: make(chan struct{})}
+	ctl.Serve(backend, env.Hooks, control.Info{Version: version, Mode: "ui"})
+
 	_, err := p.Run()
+	close(backend.done)
 	return err
 }
 